@@ -0,0 +1,208 @@
+package geecache
+
+/*
+管理接口，挂载在 /_geecache_admin/ 下：
+GET /_geecache_admin/stats          本节点所有缓存空间的统计
+GET /_geecache_admin/cluster/stats  向 HTTPPool 中的每个节点拉取统计并按缓存空间汇总
+GET /_geecache_admin/hotkeys        本节点各缓存空间访问最多的 key，可选参数 group 和 n（默认 10）
+GET /_geecache_admin/cachebytes     本节点缓存空间 group 的缓存占用情况
+PUT /_geecache_admin/cachebytes     修改本节点缓存空间 group 的容量为 bytes 字节，用于故障时在线调整
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAdminPath    = "/_geecache_admin/"
+	defaultAdminTimeout = 5 * time.Second //向其他节点拉取统计的超时时间
+	defaultHotKeysLimit = 10              //hotkeys 默认返回的 key 数量
+)
+
+// AdminHandler 返回管理接口的 http.Handler，需要挂载在 /_geecache_admin/ 路径上
+func (p *HTTPPool) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(defaultAdminPath+"stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, p.localStats())
+	})
+	mux.HandleFunc(defaultAdminPath+"cluster/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, p.ClusterStats(r.Context()))
+	})
+	mux.HandleFunc(defaultAdminPath+"hotkeys", func(w http.ResponseWriter, r *http.Request) {
+		n := defaultHotKeysLimit
+		if s := r.URL.Query().Get("n"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "bad n: "+s, http.StatusBadRequest)
+				return
+			}
+		}
+		hot := make(map[string][]HotKey)
+		for _, g := range p.registry.Groups() {
+			if name := r.URL.Query().Get("group"); name != "" && name != g.name {
+				continue
+			}
+			if keys := g.HotKeys(n); keys != nil {
+				hot[g.name] = keys
+			}
+		}
+		writeJSON(w, hot)
+	})
+	mux.HandleFunc(defaultAdminPath+"cachebytes", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("group")
+		g := p.registry.Get(name)
+		if g == nil {
+			http.Error(w, "no such group: "+name, http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			s := r.URL.Query().Get("bytes")
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n <= 0 {
+				http.Error(w, "bad bytes: "+s, http.StatusBadRequest)
+				return
+			}
+			g.SetCacheBytes(n)
+			p.logger.Info("cache resized", "group", name, "bytes", n)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, g.CacheStats())
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.authorizeAdmin(w, r) {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminScope 返回请求涉及的缓存空间：只操作单个缓存空间的接口（cachebytes、带 group 参数的 hotkeys）
+// 按该缓存空间检查 admin 权限，其余接口要求所有缓存空间（"*"）的 admin 权限
+func adminScope(r *http.Request) string {
+	switch r.URL.Path {
+	case defaultAdminPath + "cachebytes", defaultAdminPath + "hotkeys":
+		if name := r.URL.Query().Get("group"); name != "" {
+			return name
+		}
+	}
+	return "*"
+}
+
+// authorizeAdmin 放行通过节点认证的请求（来自其他节点的汇总请求），或者持有对应缓存空间 admin 权限令牌的请求。
+// 与节点接口相同，没有配置任何认证时只允许只读请求
+func (p *HTTPPool) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !p.authConfigured() {
+		p.audit.Warn("audit: access denied", "remote", r.RemoteAddr, "group", adminScope(r), "op", OpAdmin,
+			"path", r.URL.Path, "reason", "peer authentication not configured")
+		http.Error(w, "forbidden: peer authentication not configured", http.StatusForbidden)
+		return false
+	}
+	fallback := ""
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := p.authenticate(r); err == nil {
+		if len(p.secret) > 0 {
+			fallback = PeerIdentity
+		}
+	} else if p.authorizer == nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if !checkAccess(p.authorizer, p.audit, r, fallback, adminScope(r), OpAdmin) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ClusterStats 并发地向每个节点拉取统计，本节点直接读取，并按缓存空间汇总
+func (p *HTTPPool) ClusterStats(ctx context.Context) ClusterStats {
+	p.lock.Lock()
+	addrs := append([]string(nil), p.addrs...)
+	p.lock.Unlock()
+	hasSelf := false
+	for _, addr := range addrs {
+		hasSelf = hasSelf || addr == p.self
+	}
+	if !hasSelf {
+		addrs = append([]string{p.self}, addrs...)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultAdminTimeout)
+	defer cancel()
+	nodes := make([]NodeStats, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		if addr == p.self {
+			nodes[i] = p.localStats()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			ns, err := p.fetchStats(ctx, addr)
+			if err != nil {
+				ns = NodeStats{Node: addr, Error: err.Error()}
+			}
+			nodes[i] = ns
+		}(i, addr)
+	}
+	wg.Wait()
+
+	cs := ClusterStats{Nodes: nodes, Groups: make(map[string]GroupStats)}
+	for _, ns := range nodes {
+		for name, gs := range ns.Groups {
+			total := cs.Groups[name]
+			total.add(gs)
+			cs.Groups[name] = total
+		}
+	}
+	return cs
+}
+
+// localStats 本节点的统计，包括各远程节点熔断器的状态
+func (p *HTTPPool) localStats() NodeStats {
+	ns := localStats(p.self, p.registry.Groups())
+	ns.Peers = p.PeerBreakers()
+	return ns
+}
+
+// fetchStats 拉取远程节点的 /_geecache_admin/stats
+func (p *HTTPPool) fetchStats(ctx context.Context, addr string) (NodeStats, error) {
+	var ns NodeStats
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+defaultAdminPath+"stats", nil)
+	if err != nil {
+		return ns, err
+	}
+	signRequest(p.secret, req, nil)
+	res, err := p.client.Do(req)
+	if err != nil {
+		return ns, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ns, fmt.Errorf("server returned: %v", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ns); err != nil {
+		return ns, fmt.Errorf("decoding stats: %v", err)
+	}
+	ns.Node = addr
+	return ns, nil
+}
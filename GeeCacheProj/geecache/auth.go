@@ -0,0 +1,212 @@
+package geecache
+
+/*
+节点间通信的认证：
+1、TLS：节点之间使用 https 通信，可选 mTLS（服务端要求并校验客户端证书）
+2、HMAC：集群内共享一个密钥，客户端对请求签名，服务端校验签名，作为比 mTLS 更轻量的选择。
+  签名包含时间戳和随机数，写入和删除请求的随机数在时间窗口内只能使用一次，防止截获的请求被重放
+只要配置了任意一种方式，未通过认证的节点请求一律拒绝。请求 body 不超过 maxBodyBytes，在校验签名之前就限制大小
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	headerTimestamp = "X-Geecache-Timestamp" //签名时间戳（unix 秒）
+	headerSignature = "X-Geecache-Signature" //HMAC-SHA256 签名（hex）
+	headerNonce     = "X-Geecache-Nonce"     //每个请求不同的随机数（hex）
+	maxClockSkew    = 5 * time.Minute        //允许的时钟偏差，超出视为重放
+	maxBodyBytes    = 32 << 20               //节点请求 body 的上限
+)
+
+var (
+	errUnauthenticated = errors.New("unauthenticated peer request")
+	errReplayed        = errors.New("replayed peer request")
+)
+
+// TLSOptions 描述节点的证书配置，同一份配置既用于服务端也用于访问其他节点的客户端
+type TLSOptions struct {
+	CertFile          string //本节点证书
+	KeyFile           string //本节点私钥
+	CAFile            string //校验对端证书的 CA，留空则使用系统根证书
+	RequireClientCert bool   //开启 mTLS：服务端要求客户端出示由 CA 签发的证书
+}
+
+func (o TLSOptions) caPool() (*x509.CertPool, error) {
+	if o.CAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(o.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+	}
+	return pool, nil
+}
+
+// ServerConfig 生成节点 HTTP 服务使用的 tls.Config
+func (o TLSOptions) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := o.caPool()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.RequireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientConfig 生成访问其他节点时使用的 tls.Config，开启 mTLS 时带上本节点证书
+func (o TLSOptions) ClientConfig() (*tls.Config, error) {
+	pool, err := o.caPool()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.RequireClientCert {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// SetTLS 让 HTTPPool 通过 https 访问其他节点，并在开启 mTLS 时拒绝没有合法客户端证书的请求
+// 服务端的证书需要使用 opts.ServerConfig() 配置到 http.Server 上
+func (p *HTTPPool) SetTLS(opts TLSOptions) error {
+	cfg, err := opts.ClientConfig()
+	if err != nil {
+		return err
+	}
+	p.client = &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	p.requireClientCert = opts.RequireClientCert
+	return nil
+}
+
+// SetSecret 设置集群共享密钥，之后发出的节点请求都会签名，收到的节点请求都要求签名正确
+func (p *HTTPPool) SetSecret(secret []byte) {
+	p.secret = cloneBytes(secret)
+}
+
+// 签名内容：方法、路径、查询参数、时间戳、随机数以及 body 的摘要
+func signature(secret []byte, method, path, query, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%x", method, path, query, timestamp, nonce, sum)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest 在发往其他节点的请求上附加时间戳、随机数和签名
+func signRequest(secret []byte, req *http.Request, body []byte) {
+	if len(secret) == 0 {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	var b [16]byte
+	rand.Read(b[:])
+	nonce := hex.EncodeToString(b[:])
+	req.Header.Set(headerTimestamp, ts)
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, signature(secret, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, ts, nonce, body))
+}
+
+// nonceCache 记录时间窗口内见过的写请求随机数，零值可用，并发安全
+type nonceCache struct {
+	lock  sync.Mutex
+	seen  map[string]time.Time //随机数 -> 过期时间，过期后时间戳校验就会拒绝该请求
+	swept time.Time            //上次清理过期随机数的时间
+}
+
+// add 记录 nonce，时间窗口内已经见过时返回 false
+func (c *nonceCache) add(nonce string, expire time.Time) bool {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	if now.Sub(c.swept) >= time.Minute {
+		for n, e := range c.seen {
+			if now.After(e) {
+				delete(c.seen, n)
+			}
+		}
+		c.swept = now
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = expire
+	return true
+}
+
+// authenticate 校验节点请求，所有已配置的认证方式都必须通过。
+// 读取 body 之前应当用 http.MaxBytesReader 限制大小，超出时返回 *http.MaxBytesError。
+// 读请求被重放只会再拿到一次截获者已经看到的响应，所以只对其他请求检查随机数
+func (p *HTTPPool) authenticate(r *http.Request) error {
+	if p.requireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		return errUnauthenticated
+	}
+	if len(p.secret) == 0 {
+		return nil
+	}
+	ts := r.Header.Get(headerTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errUnauthenticated
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return errUnauthenticated
+	}
+	nonce := r.Header.Get(headerNonce)
+	if nonce == "" {
+		return errUnauthenticated
+	}
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body)) //body 已读完，放回去供后续处理
+	}
+	expect := signature(p.secret, r.Method, r.URL.EscapedPath(), r.URL.RawQuery, ts, nonce, body)
+	if !hmac.Equal([]byte(expect), []byte(r.Header.Get(headerSignature))) {
+		return errUnauthenticated
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !p.nonces.add(nonce, time.Unix(sec, 0).Add(maxClockSkew)) {
+		return errReplayed
+	}
+	return nil
+}
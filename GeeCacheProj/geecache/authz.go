@@ -0,0 +1,196 @@
+package geecache
+
+/*
+按缓存空间做访问控制：
+1、请求先确定身份 identity：API 令牌（Authorization: Bearer <token>）映射到身份，或使用 mTLS 客户端证书的 CN
+2、再按 identity -> group -> 允许的操作 判断是否放行，group 为 "*" 表示所有缓存空间
+被拒绝的请求都会记录审计日志，审计日志默认输出到标准库的 log，不受 Logger 默认静默的影响
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Operation 表示对缓存空间的一类操作，可以按位组合
+type Operation int
+
+const (
+	OpRead       Operation = 1 << iota //读取缓存值
+	OpWrite                            //写入缓存值
+	OpInvalidate                       //删除缓存值
+	OpAdmin                            //统计、调整容量等管理操作
+)
+
+var opNames = []struct {
+	op   Operation
+	name string
+}{
+	{OpRead, "read"},
+	{OpWrite, "write"},
+	{OpInvalidate, "invalidate"},
+	{OpAdmin, "admin"},
+}
+
+func (op Operation) String() string {
+	var names []string
+	for _, o := range opNames {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// ParseOperation 将 "read"、"write" 等名字转换为 Operation
+func ParseOperation(name string) (Operation, error) {
+	for _, o := range opNames {
+		if o.name == name {
+			return o.op, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown operation %q", name)
+}
+
+// PeerIdentity 是通过共享密钥认证的节点请求使用的身份
+const PeerIdentity = "peer"
+
+// Authorizer 决定某个请求能否对缓存空间执行某个操作
+type Authorizer interface {
+	Identify(r *http.Request) string                     //确定请求方身份，无法确定时返回空串
+	Authorize(identity, group string, op Operation) bool //判断 identity 能否对 group 执行 op
+}
+
+// ACL 是基于令牌和客户端身份的 Authorizer 实现
+type ACL struct {
+	lock   sync.RWMutex
+	tokens map[string]string               //令牌 -> 身份
+	grants map[string]map[string]Operation //身份 -> 缓存空间 -> 允许的操作
+}
+
+func NewACL() *ACL {
+	return &ACL{
+		tokens: make(map[string]string),
+		grants: make(map[string]map[string]Operation),
+	}
+}
+
+// AddToken 登记一个 API 令牌，持有该令牌的请求以 identity 的身份访问
+func (a *ACL) AddToken(token, identity string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.tokens[token] = identity
+}
+
+// Allow 允许 identity 对 group 执行 ops，group 为 "*" 表示所有缓存空间
+func (a *ACL) Allow(identity, group string, ops Operation) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.grants[identity] == nil {
+		a.grants[identity] = make(map[string]Operation)
+	}
+	a.grants[identity][group] |= ops
+}
+
+func (a *ACL) Identify(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		token, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok { //只支持 Bearer 令牌，其他认证方式（如 Basic）不能当作令牌
+			return ""
+		}
+		a.lock.RLock()
+		identity := a.tokens[token]
+		a.lock.RUnlock()
+		return identity
+	}
+	//没有令牌时使用校验通过的客户端证书
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return ""
+}
+
+func (a *ACL) Authorize(identity, group string, op Operation) bool {
+	if identity == "" {
+		return false
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	grants := a.grants[identity]
+	return grants[group]&op == op || grants["*"]&op == op
+}
+
+var _ Authorizer = (*ACL)(nil)
+
+// LoadACL 从 JSON 配置中读取 ACL，格式如下：
+//
+//	{
+//	  "tokens": {"token-a": "team-a"},
+//	  "grants": {"team-a": {"scores": ["read"]}, "peer": {"*": ["read", "write"]}}
+//	}
+func LoadACL(r io.Reader) (*ACL, error) {
+	var conf struct {
+		Tokens map[string]string              `json:"tokens"`
+		Grants map[string]map[string][]string `json:"grants"`
+	}
+	if err := json.NewDecoder(r).Decode(&conf); err != nil {
+		return nil, err
+	}
+	a := NewACL()
+	for token, identity := range conf.Tokens {
+		a.AddToken(token, identity)
+	}
+	for identity, groups := range conf.Grants {
+		for group, names := range groups {
+			for _, name := range names {
+				op, err := ParseOperation(name)
+				if err != nil {
+					return nil, err
+				}
+				a.Allow(identity, group, op)
+			}
+		}
+	}
+	return a, nil
+}
+
+// defaultAudit 审计日志的默认输出
+var defaultAudit Logger = NewStdLogger(log.Default(), LevelInfo)
+
+// Authorize 使用 a 检查请求能否对 group 执行 op，拒绝时向 audit 记录审计日志（为 nil 时输出到标准库的 log）；a 为 nil 时不做限制
+func Authorize(a Authorizer, audit Logger, r *http.Request, group string, op Operation) bool {
+	if audit == nil {
+		audit = defaultAudit
+	}
+	return checkAccess(a, audit, r, "", group, op)
+}
+
+// SetAuditLogger 设置记录被拒绝请求的审计日志，传入 nil 恢复默认（输出到标准库的 log）
+func (p *HTTPPool) SetAuditLogger(l Logger) {
+	if l == nil {
+		l = defaultAudit
+	}
+	p.audit = l
+}
+
+// checkAccess 在请求本身无法确定身份时使用 fallback（例如通过共享密钥认证的节点）
+func checkAccess(a Authorizer, audit Logger, r *http.Request, fallback, group string, op Operation) bool {
+	if a == nil {
+		return true
+	}
+	identity := a.Identify(r)
+	if identity == "" {
+		identity = fallback
+	}
+	if a.Authorize(identity, group, op) {
+		return true
+	}
+	audit.Warn("audit: access denied", "identity", identity, "remote", r.RemoteAddr,
+		"group", group, "op", op, "path", r.URL.Path)
+	return false
+}
@@ -0,0 +1,227 @@
+package geecache
+
+/*
+熔断器：数据源或远程节点出故障时快速失败，而不是让每个请求都等到超时
+1、关闭（closed）：正常放行，统计时间窗口内的失败率，请求数足够且失败率超过阈值时打开
+2、打开（open）：直接返回 ErrBreakerOpen，经过 OpenTimeout 后进入半开
+3、半开（half-open）：只放行少量试探请求，全部成功则关闭，任何一个失败则重新打开
+每个 Group 对 Getter 有一个熔断器，HTTPPool 对每个远程节点有一个熔断器，PickPeer 跳过熔断器打开的节点
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 熔断器打开时快速失败返回的错误
+var ErrBreakerOpen = errors.New("geecache: circuit breaker is open")
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerOptions 熔断器配置，零值字段使用默认值
+type BreakerOptions struct {
+	Window         time.Duration    //统计失败率的时间窗口，默认 10s
+	MinRequests    int              //窗口内请求数达到该值才判断失败率，默认 20
+	FailureRatio   float64          //失败率达到该值时打开，默认 0.5
+	OpenTimeout    time.Duration    //打开后经过多久进入半开，默认 5s
+	HalfOpenProbes int              //半开时放行的试探请求数，默认 1
+	IsFailure      func(error) bool //判断错误是否算作失败，默认所有错误都算，例如可以排除 key 不存在
+}
+
+// breaker 熔断器，nil 表示不熔断
+type breaker struct {
+	opts BreakerOptions
+
+	lock        sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int //当前窗口内的请求数
+	failures    int //当前窗口内的失败数
+	openedAt    time.Time
+	probes      int //半开时已放行的试探请求数
+	successes   int //半开时成功的试探请求数
+}
+
+func newBreaker(opts BreakerOptions) *breaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = 0.5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 5 * time.Second
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+	return &breaker{opts: opts, windowStart: time.Now()}
+}
+
+// allow 判断是否放行请求，放行后必须调用 record 报告结果
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.currentState() {
+	case BreakerOpen:
+		return ErrBreakerOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.opts.HalfOpenProbes {
+			return ErrBreakerOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// record 报告放行的请求的结果
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	failed := err != nil && (b.opts.IsFailure == nil || b.opts.IsFailure(err))
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.currentState() {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip()
+			return
+		}
+		if b.successes++; b.successes >= b.opts.HalfOpenProbes {
+			b.reset(BreakerClosed)
+		}
+	case BreakerClosed:
+		if time.Since(b.windowStart) >= b.opts.Window {
+			b.reset(BreakerClosed)
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.opts.MinRequests && float64(b.failures) >= b.opts.FailureRatio*float64(b.requests) {
+			b.trip()
+		}
+	}
+}
+
+// currentState 打开时间超过 OpenTimeout 后进入半开。调用时持有锁
+func (b *breaker) currentState() BreakerState {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.opts.OpenTimeout {
+		b.reset(BreakerHalfOpen)
+	}
+	return b.state
+}
+
+func (b *breaker) trip() {
+	b.reset(BreakerOpen)
+	b.openedAt = time.Now()
+}
+
+func (b *breaker) reset(state BreakerState) {
+	b.state = state
+	b.windowStart = time.Now()
+	b.requests, b.failures, b.probes, b.successes = 0, 0, 0, 0
+}
+
+// State 返回熔断器当前状态，nil 时为关闭
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.currentState()
+}
+
+// peerFailure 判断远程节点的请求是否算作失败：网络错误以及 502、503、504。
+// 500 通常是对方的 Getter 出错（例如 key 不存在），不代表节点故障
+func peerFailure(res *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= http.StatusBadGateway && !overloaded(res) { //过载的节点仍然正常，不算故障
+		return fmt.Errorf("server returned: %v", res.StatusCode)
+	}
+	return nil
+}
+
+// errGetterAborted Getter 没有返回（panic 或 Goexit），同样算作失败
+var errGetterAborted = errors.New("geecache: getter did not return")
+
+// SetBreaker 为 Getter 开启熔断，opts 为 nil 表示关闭（默认）。
+// 熔断器打开时 Get 未命中缓存的 key 直接返回 ErrBreakerOpen，不再调用 Getter
+func (g *Group) SetBreaker(opts *BreakerOptions) {
+	if opts == nil {
+		g.breaker = nil
+		return
+	}
+	g.breaker = newBreaker(*opts)
+}
+
+// BreakerState 返回 Getter 熔断器的状态
+func (g *Group) BreakerState() BreakerState {
+	return g.breaker.State()
+}
+
+// SetPeerBreaker 为每个远程节点开启熔断，opts 为 nil 表示关闭（默认）。需要在 Set 之前调用。
+// 节点的熔断器打开时请求直接失败，PickPeer 也会跳过该节点
+func (p *HTTPPool) SetPeerBreaker(opts *BreakerOptions) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.breakerOpts = opts
+}
+
+// newPeerBreaker 为远程节点创建熔断器，节点列表变更时保留已有节点的熔断器。调用时持有锁
+func (p *HTTPPool) newPeerBreaker(peer string, oldGetters map[string]*httpGetter) *breaker {
+	if p.breakerOpts == nil {
+		return nil
+	}
+	if old, ok := oldGetters[peer]; ok && old.breaker != nil {
+		return old.breaker
+	}
+	return newBreaker(*p.breakerOpts)
+}
+
+// PeerBreakers 返回每个远程节点熔断器的状态，未开启熔断时返回 nil
+func (p *HTTPPool) PeerBreakers() map[string]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.breakerOpts == nil {
+		return nil
+	}
+	states := make(map[string]string, len(p.httpGetters))
+	for addr, getter := range p.httpGetters {
+		if addr != p.self {
+			states[addr] = getter.breaker.State().String()
+		}
+	}
+	return states
+}
@@ -12,16 +12,105 @@ type cache struct {
 	lock       sync.Mutex
 	lru        *lru.Cache
 	cacheBytes int64 //最大缓存
+	evictions  int64 //因容量不足被淘汰的次数
+
+	onEvicted func(key string, value ByteView) //可选，在释放锁之后对本次被淘汰的缓存项调用
+	evicted   []snapshotEntry                  //add 期间被淘汰的缓存项
+
+	ghost     *lru.Cache //可选，只记录最近被淘汰的 key 和大小，用于估计增加容量能多命中多少
+	ghostHits int64      //未命中但在 ghost 中的次数
+
+	closed bool //Group 关闭后不再缓存
+}
+
+// ghostSize 被淘汰缓存项的大小，ghost 中只保存 key
+type ghostSize int
+
+func (s ghostSize) Len() int {
+	return int(s)
 }
 
 // 实例化 lru，封装 get 和 add 方法，并添加互斥锁
 func (c *cache) add(key string, value ByteView) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	if c.closed { //关闭前开始的加载不再写入缓存
+		c.lock.Unlock()
+		return
+	}
 	if c.lru == nil {
 		c.lru = lru.New(c.cacheBytes) //创建实例
+		//计入 map、链表等的开销，避免实际内存远超 cacheBytes
+		c.lru.Overhead = lru.EntryOverhead
+		c.lru.OnEvicted = func(key string, value lru.Value) {
+			c.evictions++ //在 add 持有锁时调用
+			if c.ghost != nil {
+				c.ghost.Add(key, ghostSize(value.Len()))
+			}
+			if c.onEvicted != nil {
+				c.evicted = append(c.evicted, snapshotEntry{key: key, value: value.(ByteView)})
+			}
+		}
 	}
 	c.lru.Add(key, value)
+	c.flushEvicted()
+}
+
+// flushEvicted 在持有锁时调用，释放锁之后再处理被淘汰的缓存项
+func (c *cache) flushEvicted() {
+	evicted := c.evicted
+	c.evicted = nil
+	c.lock.Unlock()
+
+	//例如降级写入磁盘，比较慢，不占用锁
+	for _, e := range evicted {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+// resize 修改最大缓存，缩小时淘汰最久未访问的缓存项
+func (c *cache) resize(cacheBytes int64) {
+	c.lock.Lock()
+	c.cacheBytes = cacheBytes
+	if c.lru != nil {
+		c.lru.Resize(cacheBytes)
+	}
+	c.flushEvicted()
+}
+
+// close 释放所有缓存项，之后 add 不再缓存
+func (c *cache) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	c.lru = nil
+	c.ghost = nil
+}
+
+// setGhost 开启或关闭（ghostBytes <= 0）对最近淘汰的 ghostBytes 字节缓存项的记录
+func (c *cache) setGhost(ghostBytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.ghost = nil
+	if ghostBytes > 0 {
+		c.ghost = lru.New(ghostBytes)
+		c.ghost.Overhead = lru.EntryOverhead //与主缓存的计算方式一致
+	}
+}
+
+// marginalHits 返回未命中但刚被淘汰过的次数，即容量再多 ghostBytes 字节时能多命中的次数
+func (c *cache) marginalHits() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ghostHits
+}
+
+// remove 删除 key 对应的缓存项
+func (c *cache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
 }
 
 func (c *cache) get(key string) (value ByteView, ok bool) {
@@ -33,5 +122,35 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 	if v, ok := c.lru.Get(key); ok {
 		return v.(ByteView), ok
 	}
+	if c.ghost != nil {
+		if _, ok := c.ghost.Get(key); ok {
+			c.ghostHits++
+		}
+	}
 	return
 }
+
+// entries 按从最久未访问到最近访问的顺序返回所有缓存项，用于快照
+func (c *cache) entries() []snapshotEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	entries := make([]snapshotEntry, 0, c.lru.Len())
+	c.lru.Range(func(key string, value lru.Value) bool {
+		entries = append(entries, snapshotEntry{key: key, value: value.(ByteView)})
+		return true
+	})
+	return entries
+}
+
+// stats 返回占用内存、最大缓存、缓存项数量和淘汰次数
+func (c *cache) stats() (bytes, maxBytes, items, evictions int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.lru == nil {
+		return 0, c.cacheBytes, 0, c.evictions
+	}
+	return c.lru.Bytes(), c.cacheBytes, int64(c.lru.Len()), c.evictions
+}
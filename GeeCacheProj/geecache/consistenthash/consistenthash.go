@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -9,11 +10,13 @@ import (
 // 函数类型 允许用于替换成自定义的 Hash 函数，也方便测试时替换，默认为 crc32.ChecksumIEEE 算法。
 type Hash func(key []byte) uint32
 
+// Map 哈希环（带虚拟节点的一致性哈希）
 type Map struct {
 	hash     Hash           //哈希函数
 	replicas int            //虚拟节点倍数
 	Nodes    []int          //哈希环
 	NodeMap  map[int]string //虚拟节点和真实节点映射表 虚拟节点：真实节点
+	weights  map[string]int //真实节点的权重
 }
 
 // Map 类型实例化 允许自定义哈希环函数和虚拟节点倍数
@@ -22,6 +25,7 @@ func New(replicas int, fn Hash) *Map {
 		hash:     fn,
 		replicas: replicas,
 		NodeMap:  make(map[int]string),
+		weights:  make(map[string]int),
 	}
 	if m.hash == nil { //如果没有自定义哈希函数，就使用默认的
 		m.hash = crc32.ChecksumIEEE
@@ -34,17 +38,44 @@ func New(replicas int, fn Hash) *Map {
 func (m *Map) Add(Nodes ...string) { //允许传入多个string类型的参数
 	for _, node := range Nodes {
 		//对每一个真实节点 key，对应创建 m.replicas 个虚拟节点
-		for i := 0; i < m.replicas; i++ {
-			xunihash := int(m.hash([]byte(strconv.Itoa(i) + node))) //使用m.hash()计算虚拟节点的哈希值
-			m.Nodes = append(m.Nodes, xunihash)
-			m.NodeMap[xunihash] = node //在 hashMap 中增加虚拟节点和真实节点的映射关系。
-		}
-
+		m.addVirtual(node, 1)
 	}
 	sort.Ints(m.Nodes) //排序
 
 }
 
+// AddWeighted 按权重添加真实节点，虚拟节点数为 replicas*weight，
+// 例如 64GB 的机器权重为 8、8GB 的机器权重为 1，分到的 key 大约是 8 倍
+func (m *Map) AddWeighted(weights map[string]int) {
+	for _, node := range sortedNodes(weights) { //按固定顺序添加，哈希冲突时各节点得到相同的结果
+		m.addVirtual(node, weights[node])
+	}
+	sort.Ints(m.Nodes)
+}
+
+// 虚拟节点哈希冲突时最多重试的次数
+const maxProbes = 16
+
+func (m *Map) addVirtual(node string, weight int) {
+	m.weights[node] += weight
+	for i := 0; i < m.replicas*weight; i++ {
+		name := strconv.Itoa(i) + node
+		xunihash := int(m.hash([]byte(name))) //使用m.hash()计算虚拟节点的哈希值
+		//与已有的虚拟节点冲突时换一个名字重新计算，而不是覆盖别的节点的虚拟节点
+		for j := 1; j <= maxProbes; j++ {
+			if _, ok := m.NodeMap[xunihash]; !ok {
+				break
+			}
+			xunihash = int(m.hash([]byte(name + "#" + strconv.Itoa(j))))
+		}
+		if _, ok := m.NodeMap[xunihash]; ok { //仍然冲突，放弃这个虚拟节点
+			continue
+		}
+		m.Nodes = append(m.Nodes, xunihash)
+		m.NodeMap[xunihash] = node //在 hashMap 中增加虚拟节点和真实节点的映射关系。
+	}
+}
+
 // 查询Peer，通过key，去查询key所在的节点
 func (m *Map) Get(key string) string {
 	if len(m.Nodes) == 0 { //如果没有缓存服务器
@@ -62,3 +93,61 @@ func (m *Map) Get(key string) string {
 	return m.NodeMap[v]
 
 }
+
+// GetN 从 key 的位置顺时针查找，返回最先遇到的 n 个不同的真实节点
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.Nodes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.weights) {
+		n = len(m.weights)
+	}
+	keyhash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.Nodes), func(i int) bool {
+		return m.Nodes[i] >= keyhash
+	})
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(m.Nodes) && len(nodes) < n; i++ {
+		if node := m.NodeMap[m.Nodes[(idx+i)%len(m.Nodes)]]; !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// GetBounded 有界负载的一致性哈希（consistent hashing with bounded loads）：
+// 每个节点的负载上限为 ceil((总负载+1) * 权重占比 * (1+epsilon))，
+// 从 key 的位置顺时针查找，跳过已达到上限的节点。load 返回节点当前的负载（例如正在处理的请求数）
+func (m *Map) GetBounded(key string, epsilon float64, load func(node string) int64) string {
+	if len(m.Nodes) == 0 {
+		return ""
+	}
+	var total int64
+	var totalWeight int
+	for node, w := range m.weights {
+		total += load(node)
+		totalWeight += w
+	}
+	limit := func(node string) int64 {
+		share := float64(m.weights[node]) / float64(totalWeight)
+		return int64(math.Ceil(float64(total+1) * share * (1 + epsilon)))
+	}
+
+	keyhash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.Nodes), func(i int) bool {
+		return m.Nodes[i] >= keyhash
+	})
+	checked := make(map[string]bool, len(m.weights))
+	for i := 0; i < len(m.Nodes) && len(checked) < len(m.weights); i++ {
+		node := m.NodeMap[m.Nodes[(idx+i)%len(m.Nodes)]]
+		if checked[node] {
+			continue
+		}
+		checked[node] = true
+		if load(node) < limit(node) {
+			return node
+		}
+	}
+	//上限保证总有节点未满，这里只是兜底
+	return m.NodeMap[m.Nodes[idx%len(m.Nodes)]]
+}
@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -40,3 +41,71 @@ func TestHashing(t *testing.T) {
 	}
 
 }
+
+// 统计 n 个 key 在各个节点上的分布比例
+func distribution(m *Map, n int) map[string]float64 {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[m.Get("key-"+strconv.Itoa(i))]++
+	}
+	shares := make(map[string]float64, len(counts))
+	for node, c := range counts {
+		shares[node] = float64(c) / float64(n)
+	}
+	return shares
+}
+
+// 测试权重：每个节点分到的 key 比例接近它的权重占比
+func TestWeightedDistribution(t *testing.T) {
+	weights := map[string]int{
+		"http://10.0.0.1:8001": 1,
+		"http://10.0.0.2:8001": 1,
+		"http://10.0.0.3:8001": 8,
+	}
+	m := New(50, nil)
+	m.AddWeighted(weights)
+	shares := distribution(m, 100000)
+	for node, w := range weights {
+		expect := float64(w) / 10
+		if math.Abs(shares[node]-expect) > 0.05 {
+			t.Errorf("node %s with weight %d got %.3f of keys, expect about %.3f", node, w, shares[node], expect)
+		}
+	}
+}
+
+// 测试权重为 1 时与 Add 的结果一致
+func TestWeightOne(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	m1, m2 := New(50, nil), New(50, nil)
+	m1.Add(nodes...)
+	m2.AddWeighted(map[string]int{"a": 1, "b": 1, "c": 1})
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if m1.Get(key) != m2.Get(key) {
+			t.Fatalf("key %s maps to %s with Add but %s with AddWeighted", key, m1.Get(key), m2.Get(key))
+		}
+	}
+}
+
+// 测试有界负载：负责节点达到上限时顺时针找下一个节点
+func TestGetBounded(t *testing.T) {
+	m := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	m.Add("6", "2", "4") //虚拟节点 2 4 6 12 14 16 22 24 26
+
+	loads := map[string]int64{}
+	load := func(node string) int64 { return loads[node] }
+	if node := m.GetBounded("11", 0.25, load); node != "2" {
+		t.Fatalf("without load key 11 should map to 2, got %s", node)
+	}
+	//总负载 3，上限 ceil(4/3*1.25)=2，节点 2 已满，顺时针下一个是 14 对应的节点 4
+	loads["2"] = 3
+	if node := m.GetBounded("11", 0.25, load); node != "4" {
+		t.Fatalf("key 11 should overflow to 4, got %s", node)
+	}
+	if node := m.Get("11"); node != "2" {
+		t.Fatalf("Get should ignore load, got %s", node)
+	}
+}
@@ -0,0 +1,71 @@
+package consistenthash
+
+import "hash/crc32"
+
+// Jump 跳跃一致性哈希（Lamping & Veach）。每个真实节点按权重占用若干个桶，
+// 同一次 AddWeighted 中的节点按名字排序后依次追加在末尾，因此只适合新节点的名字排在最后、
+// 且只删除最后加入的节点的场景，否则会有大量 key 迁移
+type Jump struct {
+	hash    Hash
+	buckets []string //桶号到真实节点
+}
+
+// NewJump 实例化，fn 为 nil 时使用 crc32.ChecksumIEEE
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Jump{hash: fn}
+}
+
+// AddWeighted 按权重添加真实节点，权重为 w 的节点占用 w 个桶
+func (j *Jump) AddWeighted(weights map[string]int) {
+	for _, node := range sortedNodes(weights) {
+		for i := 0; i < weights[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
+
+// Get 返回 key 所在桶对应的真实节点
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(mix64(uint64(j.hash([]byte(key)))), len(j.buckets))]
+}
+
+// GetN 第一个节点与 Get 相同，之后用加盐的 key 继续跳跃，跳过已选中的节点；
+// 多次重试仍不足 n 个时按桶的顺序补齐
+func (j *Jump) GetN(key string, n int) []string {
+	if len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+	keyhash := mix64(uint64(j.hash([]byte(key))))
+	nodes := []string{j.buckets[jumpHash(keyhash, len(j.buckets))]}
+	for salt := uint64(1); len(nodes) < n && salt <= uint64(16*n); salt++ {
+		if node := j.buckets[jumpHash(mix64(keyhash+salt), len(j.buckets))]; !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	for _, node := range j.buckets {
+		if len(nodes) >= n {
+			break
+		}
+		if !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// jumpHash 把 key 映射到 [0, buckets) 中的一个桶，桶数从 n 变为 n+1 时只有 1/(n+1) 的 key 移动到新桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
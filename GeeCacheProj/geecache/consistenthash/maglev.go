@@ -0,0 +1,112 @@
+package consistenthash
+
+import "hash/crc32"
+
+// 查找表默认大小，必须是质数，且远大于节点数（论文建议至少 100 倍）
+const defaultMaglevSize = 65537
+
+// Maglev Maglev 一致性哈希。每个节点由名字得到 offset 和 skip，依次按 offset + j*skip 的顺序占领查找表中的空位，
+// 各节点轮流占领直到填满，权重为 w 的节点每轮占领 w 个位置
+type Maglev struct {
+	hash    Hash
+	size    int
+	nodes   []string
+	weights []int
+	table   []int //查找表，值为 nodes 的下标
+}
+
+// NewMaglev 实例化，size 为查找表大小（质数），<= 0 或不是质数时使用 65537；fn 为 nil 时使用 crc32.ChecksumIEEE。
+// size 不是质数时 skip 可能与 size 不互质，节点的排列遍历不到所有位置，填充查找表会陷入死循环
+func NewMaglev(size int, fn Hash) *Maglev {
+	if size <= 0 || !isPrime(size) {
+		size = defaultMaglevSize
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Maglev{hash: fn, size: size}
+}
+
+// isPrime 判断 n 是否为质数，查找表不大，试除即可
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// AddWeighted 按权重添加真实节点并重建查找表
+func (m *Maglev) AddWeighted(weights map[string]int) {
+	for _, node := range sortedNodes(weights) {
+		m.nodes = append(m.nodes, node)
+		m.weights = append(m.weights, weights[node])
+	}
+	m.populate()
+}
+
+// populate 按 Maglev 论文的算法填充查找表
+func (m *Maglev) populate() {
+	if len(m.nodes) == 0 {
+		return
+	}
+	size := uint64(m.size)
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	next := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := mix64(uint64(m.hash([]byte(node))))
+		offsets[i] = h % size
+		skips[i] = (h>>32)%(size-1) + 1
+	}
+
+	m.table = make([]int, m.size)
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	for filled := 0; filled < m.size; {
+		for i := range m.nodes {
+			for w := 0; w < m.weights[i] && filled < m.size; w++ {
+				//找到该节点排列中下一个空位
+				slot := (offsets[i] + next[i]*skips[i]) % size
+				for m.table[slot] >= 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % size
+				}
+				m.table[slot] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+}
+
+// Get 返回 key 在查找表中对应的真实节点
+func (m *Maglev) Get(key string) string {
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[mix64(uint64(m.hash([]byte(key))))%uint64(m.size)]]
+}
+
+// GetN 从 key 在查找表中的位置往后查找，返回最先遇到的 n 个不同的真实节点
+func (m *Maglev) GetN(key string, n int) []string {
+	if len(m.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	slot := int(mix64(uint64(m.hash([]byte(key)))) % uint64(m.size))
+	nodes := make([]string, 0, n)
+	for i := 0; i < m.size && len(nodes) < n; i++ {
+		if node := m.nodes[m.table[(slot+i)%m.size]]; !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,65 @@
+package consistenthash
+
+/*
+节点放置算法：把 key 映射到真实节点，HTTPPool 只依赖 Placement 接口
+1、Map：哈希环 + 虚拟节点，增删节点只影响相邻区间，但虚拟节点少时分布不够均匀
+2、Rendezvous：最高随机权重（HRW），每个 key 选择与它组合得分最高的节点，分布均匀、不需要虚拟节点，查询是 O(节点数)
+3、Jump：跳跃一致性哈希，几乎不占内存、分布最均匀，但只能在末尾增删节点，删除中间的节点会导致大量迁移
+4、Maglev：按节点的排列填充固定大小的查找表，查询 O(1)、分布均匀，增删节点时有少量额外迁移
+*/
+
+import "sort"
+
+// Placement 节点放置算法
+type Placement interface {
+	// AddWeighted 按权重添加真实节点，权重 <= 0 的节点被忽略
+	AddWeighted(weights map[string]int)
+	// Get 返回 key 所属的真实节点，没有节点时返回空字符串
+	Get(key string) string
+	// GetN 按优先级返回 key 的 n 个不同的真实节点，第一个与 Get 相同；节点不足 n 个时返回全部节点
+	GetN(key string, n int) []string
+}
+
+var (
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// sortedNodes 返回权重大于 0 的节点，按名字排序，保证每个节点构建出相同的结果
+func sortedNodes(weights map[string]int) []string {
+	nodes := make([]string, 0, len(weights))
+	for node, weight := range weights {
+		if weight > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// contains 判断 nodes 中是否已有 node，副本数很小，线性查找即可
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// mix64 murmur3 的 fmix64，把 32 位哈希值扩散到 64 位，弥补 crc32 等哈希低位分布不均的问题
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// unitFloat 把 64 位哈希值映射到 (0, 1)
+func unitFloat(x uint64) float64 {
+	return (float64(x>>11) + 0.5) / (1 << 53)
+}
@@ -0,0 +1,149 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+// 参与比较的放置算法
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", func() Placement { return New(50, nil) }},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }},
+	{"jump", func() Placement { return NewJump(nil) }},
+	{"maglev", func() Placement { return NewMaglev(0, nil) }},
+}
+
+// nodeWeights 返回 n 个权重为 1 的节点，名字按加入顺序排序（Jump 只能在末尾增加节点）
+func nodeWeights(n int) map[string]int {
+	weights := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		weights["http://10.0.0."+strconv.Itoa(100+i)+":8001"] = 1
+	}
+	return weights
+}
+
+// 测试分布和迁移量：比较各算法最大负载与平均负载之比，以及增加一个节点时迁移的 key 比例。
+// go test -v -run TestPlacement 可以看到对比结果
+func TestPlacement(t *testing.T) {
+	const nodes, keys = 10, 100000
+	for _, pc := range placements {
+		t.Run(pc.name, func(t *testing.T) {
+			before := pc.new()
+			before.AddWeighted(nodeWeights(nodes))
+			after := pc.new()
+			after.AddWeighted(nodeWeights(nodes + 1))
+			newNode := "http://10.0.0." + strconv.Itoa(100+nodes) + ":8001"
+
+			counts := make(map[string]int)
+			moved := 0
+			for i := 0; i < keys; i++ {
+				key := "key-" + strconv.Itoa(i)
+				owner := before.Get(key)
+				counts[owner]++
+				if now := after.Get(key); now != owner {
+					moved++
+					if now != newNode && pc.name != "maglev" { //Maglev 允许少量 key 在旧节点之间移动
+						t.Fatalf("key %s moved from %s to %s, not to the new node", key, owner, now)
+					}
+				}
+			}
+			max := 0
+			for _, c := range counts {
+				if c > max {
+					max = c
+				}
+			}
+			imbalance := float64(max) / (float64(keys) / nodes)
+			churn := float64(moved) / keys
+			t.Logf("%-10s max/avg %.3f, moved %.3f (ideal %.3f)", pc.name, imbalance, churn, 1.0/(nodes+1))
+			if len(counts) != nodes || imbalance > 1.5 {
+				t.Errorf("unbalanced placement: %d nodes used, max/avg %.3f", len(counts), imbalance)
+			}
+			if churn > 2.0/(nodes+1) {
+				t.Errorf("adding one node moved %.3f of keys", churn)
+			}
+		})
+	}
+}
+
+// 测试权重：权重 3 的节点分到的 key 约为权重 1 的 3 倍
+func TestPlacementWeighted(t *testing.T) {
+	const keys = 100000
+	for _, pc := range placements {
+		p := pc.new()
+		p.AddWeighted(map[string]int{"a": 1, "b": 1, "c": 3})
+		counts := make(map[string]int)
+		for i := 0; i < keys; i++ {
+			counts[p.Get("key-"+strconv.Itoa(i))]++
+		}
+		if share := float64(counts["c"]) / keys; share < 0.5 || share > 0.7 {
+			t.Errorf("%s: node with weight 3 got %.3f of keys, expect about 0.6", pc.name, share)
+		}
+	}
+}
+
+// 测试哈希冲突：两个节点的虚拟节点哈希值相同时，后加入的节点换一个位置，而不是覆盖已有的虚拟节点
+func TestCollision(t *testing.T) {
+	m := New(1, func(key []byte) uint32 {
+		if len(key) > 2 { //带冲突后缀的名字
+			return 100
+		}
+		return 1
+	})
+	m.Add("a", "b") //0a 和 0b 的哈希值都是 1
+	if len(m.Nodes) != 2 || m.NodeMap[1] != "a" || m.NodeMap[100] != "b" {
+		t.Fatalf("virtual node collision not resolved: %v %v", m.Nodes, m.NodeMap)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, pc := range placements {
+		for _, n := range []int{10, 100} {
+			p := pc.new()
+			p.AddWeighted(nodeWeights(n))
+			b.Run(pc.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.Get("key-" + strconv.Itoa(i&1023))
+				}
+			})
+		}
+	}
+}
+
+// 测试 GetN：返回不同的真实节点，第一个与 Get 相同，节点不足时返回全部
+func TestGetN(t *testing.T) {
+	for _, pc := range placements {
+		p := pc.new()
+		p.AddWeighted(nodeWeights(5))
+		for i := 0; i < 1000; i++ {
+			key := "key-" + strconv.Itoa(i)
+			nodes := p.GetN(key, 3)
+			if len(nodes) != 3 || nodes[0] != p.Get(key) {
+				t.Fatalf("%s: GetN(%s, 3) = %v, Get = %s", pc.name, key, nodes, p.Get(key))
+			}
+			if nodes[0] == nodes[1] || nodes[0] == nodes[2] || nodes[1] == nodes[2] {
+				t.Fatalf("%s: GetN(%s, 3) returned duplicate nodes %v", pc.name, key, nodes)
+			}
+		}
+		if nodes := p.GetN("Tom", 10); len(nodes) != 5 {
+			t.Fatalf("%s: GetN with n > nodes should return all 5 nodes, got %v", pc.name, nodes)
+		}
+	}
+}
+
+// 测试 Maglev 查找表大小：不是质数时回退到默认大小，不会在填充查找表时死循环
+func TestMaglevSize(t *testing.T) {
+	for size, want := range map[int]int{0: defaultMaglevSize, 100: defaultMaglevSize, 65536: defaultMaglevSize, 1: defaultMaglevSize, 101: 101} {
+		m := NewMaglev(size, nil)
+		if m.size != want {
+			t.Fatalf("NewMaglev(%d) size = %d, want %d", size, m.size, want)
+		}
+		m.AddWeighted(nodeWeights(5))
+		if m.Get("Tom") == "" {
+			t.Fatalf("NewMaglev(%d) returned no node", size)
+		}
+	}
+}
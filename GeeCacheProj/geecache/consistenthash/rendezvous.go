@@ -0,0 +1,77 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+// Rendezvous 最高随机权重哈希（HRW）。带权重时得分为 -weight/ln(u)，u 为 key 与节点组合后的哈希值映射到 (0,1)，
+// 这样每个节点被选中的概率正好是它的权重占比
+type Rendezvous struct {
+	hash    Hash
+	nodes   []string
+	seeds   []uint64 //每个节点名字的哈希值，查询时与 key 的哈希组合
+	weights []float64
+}
+
+// NewRendezvous 实例化，fn 为 nil 时使用 crc32.ChecksumIEEE
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{hash: fn}
+}
+
+// AddWeighted 按权重添加真实节点
+func (r *Rendezvous) AddWeighted(weights map[string]int) {
+	for _, node := range sortedNodes(weights) {
+		r.nodes = append(r.nodes, node)
+		r.seeds = append(r.seeds, mix64(uint64(r.hash([]byte(node)))))
+		r.weights = append(r.weights, float64(weights[node]))
+	}
+}
+
+// score key 在第 i 个节点上的得分
+func (r *Rendezvous) score(keyhash uint64, i int) float64 {
+	u := unitFloat(mix64(keyhash ^ r.seeds[i]))
+	return -r.weights[i] / math.Log(u)
+}
+
+// Get 返回得分最高的节点
+func (r *Rendezvous) Get(key string) string {
+	if len(r.nodes) == 0 {
+		return ""
+	}
+	keyhash := mix64(uint64(r.hash([]byte(key))))
+	best, bestScore := 0, math.Inf(-1)
+	for i := range r.nodes {
+		if s := r.score(keyhash, i); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return r.nodes[best]
+}
+
+// GetN 返回得分最高的 n 个节点，按得分从高到低排列
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	keyhash := mix64(uint64(r.hash([]byte(key))))
+	order := make([]int, len(r.nodes))
+	scores := make([]float64, len(r.nodes))
+	for i := range r.nodes {
+		order[i] = i
+		scores[i] = r.score(keyhash, i)
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	if n > len(order) {
+		n = len(order)
+	}
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = r.nodes[order[i]]
+	}
+	return nodes
+}
@@ -0,0 +1,302 @@
+package disk
+
+/*
+磁盘二级缓存：追加写的日志文件 + 内存索引
+1、每条记录：crc32(4) | 类型(1) | key 长度(4) | value 长度(4) | key | value，crc 覆盖类型之后的所有字节
+2、写入只在文件末尾追加，覆盖或删除旧值只是让旧记录变成垃圾
+3、启动时顺序扫描日志重建索引，遇到写了一半或校验失败的记录就把文件截断到这里（崩溃恢复）
+4、活跃数据超过预算时按写入顺序淘汰最早的记录；垃圾占比过高时重写日志（压缩）
+*/
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	headerSize = 13
+
+	recordPut    = 1
+	recordDelete = 2
+
+	minCompactSize = 1 << 20 //日志小于 1MB 时不压缩
+)
+
+var errCorrupt = errors.New("disk: corrupt record")
+
+// item 索引中的一项，记录 key 对应的记录在日志中的位置
+type item struct {
+	key    string
+	offset int64 //记录起始位置
+	size   int64 //记录总长度
+	ele    *list.Element
+}
+
+// Store 磁盘存储，并发安全
+type Store struct {
+	lock     sync.Mutex
+	path     string
+	f        *os.File
+	index    map[string]*item
+	order    *list.List //按写入顺序排列，队首最早，预算不足时从队首淘汰
+	size     int64      //日志文件长度
+	live     int64      //活跃记录的总长度
+	maxBytes int64      //活跃数据的上限，0 表示不限制
+
+	OnEvicted func(key string) //可选，因预算不足被淘汰时调用
+}
+
+// Open 打开（不存在时创建）path 处的日志并重建索引
+func Open(path string, maxBytes int64) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		path:     path,
+		f:        f,
+		index:    make(map[string]*item),
+		order:    list.New(),
+		maxBytes: maxBytes,
+	}
+	if err := s.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.evict()
+	return s, nil
+}
+
+// recover 顺序扫描日志，截断末尾不完整或损坏的记录
+func (s *Store) recover() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = info.Size() //扫描期间作为记录长度的上界
+	var offset int64
+	for {
+		kind, key, _, size, err := s.readRecord(offset, false)
+		if err == io.EOF {
+			break
+		}
+		if err != nil { //崩溃时写了一半的记录，丢弃它及之后的内容
+			if err := s.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		switch kind {
+		case recordPut:
+			s.setIndex(key, offset, size)
+		case recordDelete:
+			s.removeIndex(key)
+		}
+		offset += size
+	}
+	s.size = offset
+	return nil
+}
+
+// readRecord 读取 offset 处的记录，withValue 为 false 时只校验不返回 value
+func (s *Store) readRecord(offset int64, withValue bool) (kind byte, key string, value []byte, size int64, err error) {
+	var header [headerSize]byte
+	if n, err := s.f.ReadAt(header[:], offset); err != nil {
+		if n == 0 && err == io.EOF {
+			return 0, "", nil, 0, io.EOF
+		}
+		return 0, "", nil, 0, errCorrupt
+	}
+	kind = header[4]
+	keyLen := binary.BigEndian.Uint32(header[5:9])
+	valLen := binary.BigEndian.Uint32(header[9:13])
+	size = headerSize + int64(keyLen) + int64(valLen)
+	if (kind != recordPut && kind != recordDelete) || offset+size > s.size {
+		return 0, "", nil, 0, errCorrupt
+	}
+	body := make([]byte, int64(keyLen)+int64(valLen))
+	if _, err = s.f.ReadAt(body, offset+headerSize); err != nil {
+		return 0, "", nil, 0, errCorrupt
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+		return 0, "", nil, 0, errCorrupt
+	}
+	key = string(body[:keyLen])
+	if withValue {
+		value = body[keyLen:]
+	}
+	return kind, key, value, size, nil
+}
+
+// append 在日志末尾追加一条记录，返回记录的起始位置和长度
+func (s *Store) append(kind byte, key string, value []byte) (int64, int64, error) {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = kind
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(value)))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(buf[4:]))
+	offset := s.size
+	if _, err := s.f.WriteAt(buf, offset); err != nil {
+		return 0, 0, err
+	}
+	s.size += int64(len(buf))
+	return offset, int64(len(buf)), nil
+}
+
+func (s *Store) setIndex(key string, offset, size int64) {
+	s.removeIndex(key)
+	it := &item{key: key, offset: offset, size: size}
+	it.ele = s.order.PushBack(it)
+	s.index[key] = it
+	s.live += size
+}
+
+func (s *Store) removeIndex(key string) bool {
+	it, ok := s.index[key]
+	if !ok {
+		return false
+	}
+	s.order.Remove(it.ele)
+	delete(s.index, key)
+	s.live -= it.size
+	return true
+}
+
+// evict 活跃数据超出预算时按写入顺序淘汰，淘汰不写删除记录：重启时同样按预算淘汰即可。
+// 之后其他 key 被删除会腾出预算，重启时被淘汰的 key 可能重新出现，所以 Delete 总是写删除记录
+func (s *Store) evict() {
+	for s.maxBytes != 0 && s.live > s.maxBytes {
+		it := s.order.Front().Value.(*item)
+		s.removeIndex(it.key)
+		if s.OnEvicted != nil {
+			s.OnEvicted(it.key)
+		}
+	}
+}
+
+// Get 读取 key 对应的值
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	it, ok := s.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+	_, _, value, _, err := s.readRecord(it.offset, true)
+	if err != nil {
+		s.removeIndex(key)
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Put 写入 key 对应的值，覆盖旧值
+func (s *Store) Put(key string, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	offset, size, err := s.append(recordPut, key, value)
+	if err != nil {
+		return err
+	}
+	s.setIndex(key, offset, size)
+	s.evict()
+	return s.maybeCompact()
+}
+
+// Delete 删除 key，写入删除记录以便重启后仍然生效。
+// key 不在索引中也写删除记录：它可能已被淘汰，日志里仍有旧值
+func (s *Store) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.removeIndex(key)
+	if _, _, err := s.append(recordDelete, key, nil); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// Len 返回存储的 key 数量
+func (s *Store) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.index)
+}
+
+// Bytes 返回活跃数据的大小
+func (s *Store) Bytes() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.live
+}
+
+// maybeCompact 日志中一半以上是垃圾时压缩
+func (s *Store) maybeCompact() error {
+	if s.size < minCompactSize || s.size < 2*s.live {
+		return nil
+	}
+	return s.compact()
+}
+
+// Compact 把活跃记录按原顺序写入新文件，然后原子地替换旧日志
+func (s *Store) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compact()
+}
+
+func (s *Store) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	offsets := make(map[*item]int64, len(s.index))
+	for ele := s.order.Front(); ele != nil; ele = ele.Next() {
+		it := ele.Value.(*item)
+		buf := make([]byte, it.size)
+		if _, err = s.f.ReadAt(buf, it.offset); err == nil {
+			_, err = tmp.WriteAt(buf, offset)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		offsets[it] = offset
+		offset += it.size
+	}
+	//先落盘再重命名，崩溃时要么是旧日志要么是完整的新日志
+	if err = tmp.Sync(); err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	s.f.Close()
+	s.f = tmp
+	s.size = offset
+	for it, off := range offsets {
+		it.offset = off
+	}
+	return nil
+}
+
+// Close 关闭日志文件
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.f.Close()
+}
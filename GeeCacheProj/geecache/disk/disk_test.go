@@ -0,0 +1,131 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openStore(t *testing.T, path string, maxBytes int64) *Store {
+	t.Helper()
+	s, err := Open(path, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 测试读写以及重启后恢复
+func TestPutGetRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s := openStore(t, path, 0)
+	s.Put("Tom", []byte("630"))
+	s.Put("Jack", []byte("589"))
+	s.Put("Tom", []byte("631")) //覆盖
+	s.Delete("Jack")
+	if v, ok, _ := s.Get("Tom"); !ok || string(v) != "631" {
+		t.Fatalf("get Tom = %q, %v", v, ok)
+	}
+	s.Close()
+
+	s = openStore(t, path, 0)
+	defer s.Close()
+	if v, ok, _ := s.Get("Tom"); !ok || string(v) != "631" {
+		t.Fatalf("after reopen get Tom = %q, %v", v, ok)
+	}
+	if _, ok, _ := s.Get("Jack"); ok || s.Len() != 1 {
+		t.Fatalf("deleted key Jack should stay deleted after reopen")
+	}
+}
+
+// 测试崩溃恢复：末尾写了一半的记录被截断，之前的记录不受影响
+func TestTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s := openStore(t, path, 0)
+	s.Put("Tom", []byte("630"))
+	s.Put("Jack", []byte("589"))
+	size := s.size
+	s.Close()
+
+	os.Truncate(path, size-2) //模拟写 Jack 时崩溃
+	s = openStore(t, path, 0)
+	if _, ok, _ := s.Get("Jack"); ok {
+		t.Fatalf("torn record Jack should be dropped")
+	}
+	if v, ok, _ := s.Get("Tom"); !ok || string(v) != "630" {
+		t.Fatalf("get Tom = %q, %v", v, ok)
+	}
+	s.Put("Sam", []byte("567")) //截断后可以继续追加
+	s.Close()
+
+	s = openStore(t, path, 0)
+	defer s.Close()
+	if v, ok, _ := s.Get("Sam"); !ok || string(v) != "567" || s.Len() != 2 {
+		t.Fatalf("get Sam = %q, %v, len %d", v, ok, s.Len())
+	}
+}
+
+// 测试预算：超出时淘汰最早写入的记录
+func TestEvict(t *testing.T) {
+	record := int64(headerSize + len("k1") + len("v1"))
+	s := openStore(t, filepath.Join(t.TempDir(), "l2.log"), 2*record)
+	defer s.Close()
+	var evicted []string
+	s.OnEvicted = func(key string) { evicted = append(evicted, key) }
+	s.Put("k1", []byte("v1"))
+	s.Put("k2", []byte("v2"))
+	s.Put("k3", []byte("v3"))
+	if _, ok, _ := s.Get("k1"); ok || len(evicted) != 1 || evicted[0] != "k1" || s.Bytes() != 2*record {
+		t.Fatalf("k1 should be evicted, got evicted=%v bytes=%d", evicted, s.Bytes())
+	}
+}
+
+// 测试淘汰后删除：重启时删除了其他 key 腾出预算，被淘汰又删除的 key 不能带着旧值重新出现
+func TestDeleteEvicted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	record := int64(headerSize + len("k1") + len("v1"))
+	s := openStore(t, path, 2*record)
+	s.Put("k1", []byte("v1"))
+	s.Put("k2", []byte("v2"))
+	s.Put("k3", []byte("v3")) //淘汰 k1
+	if err := s.Delete("k1"); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete("k2")
+	s.Close()
+
+	s = openStore(t, path, 2*record)
+	defer s.Close()
+	if v, ok, _ := s.Get("k1"); ok {
+		t.Fatalf("deleted key k1 came back after reopen with %q", v)
+	}
+	if s.Len() != 1 {
+		t.Fatalf("expect only k3 after reopen, got %d keys", s.Len())
+	}
+}
+
+// 测试压缩：只保留活跃记录，压缩后仍能读取并恢复
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "l2.log")
+	s := openStore(t, path, 0)
+	for i := 0; i < 10; i++ {
+		s.Put("Tom", []byte{byte(i)})
+	}
+	s.Put("Jack", []byte("589"))
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.size != s.live {
+		t.Fatalf("log size %d should equal live bytes %d after compaction", s.size, s.live)
+	}
+	s.Close()
+
+	s = openStore(t, path, 0)
+	defer s.Close()
+	if v, ok, _ := s.Get("Tom"); !ok || v[0] != 9 {
+		t.Fatalf("get Tom = %v, %v", v, ok)
+	}
+	if v, ok, _ := s.Get("Jack"); !ok || string(v) != "589" {
+		t.Fatalf("get Jack = %q, %v", v, ok)
+	}
+}
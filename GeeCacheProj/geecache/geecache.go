@@ -20,11 +20,15 @@ Group缓存空间结构体包含cache , cache包含*lru.Cache
 */
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"geecache/disk"
 	pb "geecache/geecachepb"
 	"geecache/singleflight"
-	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //设计一个回调函数，当缓存不存在时，调用这个函数，得到源数据
@@ -49,51 +53,233 @@ type Group struct {
 	name      string
 	getter    Getter
 	mainCache cache
+	hotCache  cache //其他节点推送来的热点 key，它们的负责节点不是本节点
 	peers     PeerPicker
 
-	loader *singleflight.ManegeCall
-}
+	loader  *singleflight.ManegeCall
+	metrics *groupMetrics //命中、加载、延迟等指标
+	logger  Logger        //默认不输出日志
+	tracer  Tracer        //默认不做追踪
+	disk    *disk.Store   //可选的磁盘二级缓存，内存中淘汰的缓存项降级到这里
 
-var (
-	rwlock sync.RWMutex //读写锁
-	groups = make(map[string]*Group)
-)
+	replicas int          //副本数，大于 1 时缓存值会复制到多个负责节点
+	breaker  *breaker     //可选的 Getter 熔断器
+	limiter  *loadLimiter //可选的并发加载限制
+
+	hotKeys      *topK //可选的热点 key 统计
+	hotThreshold int64 //一个窗口内访问次数达到该值的 key 被推送到所有节点
 
-// 实例化Group
+	registry  *Registry          //所在的注册表
+	ctx       context.Context    //后台任务（热点推送、副本复制）使用，Close 时取消
+	cancel    context.CancelFunc //取消 ctx
+	closed    int32              //Close 之后为 1
+	closeLock sync.Mutex         //保护 closers
+	closers   []func()           //Close 时调用，例如从 MemoryManager 中注销
+}
+
+// 实例化Group，注册到默认的全局注册表中。同名的 Group 已存在时直接覆盖，旧 Group 不会关闭（与原来的行为一致）；
+// 需要检查重名时使用 Registry.NewGroup，需要替换并关闭旧 Group 时使用 ReplaceGroup
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+	g := newGroup(name, cacheBytes, getter)
+	defaultRegistry.put(g)
+	return g
+}
+
+// ReplaceGroup 在默认的全局注册表中创建 Group，同名的旧 Group 被替换并关闭
+func ReplaceGroup(name string, cacheBytes int64, getter Getter) *Group {
+	return defaultRegistry.ReplaceGroup(name, cacheBytes, getter)
+}
+
+func GetGroup(name string) *Group {
+	return defaultRegistry.Get(name)
+}
+
+// DeleteGroup 从默认的全局注册表中删除并关闭 Group，不存在时返回 false
+func DeleteGroup(name string) bool {
+	return defaultRegistry.Delete(name)
+}
+
+func newGroup(name string, cacheBytes int64, getter Getter) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
-	rwlock.Lock() //写锁
-	defer rwlock.Unlock()
-	g := &Group{
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{
 		name:      name,
 		getter:    getter,
 		mainCache: cache{cacheBytes: cacheBytes},
+		hotCache:  cache{cacheBytes: hotCacheBytes(cacheBytes)},
 		loader:    &singleflight.ManegeCall{},
+		metrics:   newGroupMetrics(),
+		logger:    nopLogger{},
+		tracer:    nopTracer{},
+		ctx:       ctx,
+		cancel:    cancel,
 	}
-	groups[name] = g //将 group 存储在全局变量 groups 中
-	return g
 }
-func GetGroup(name string) *Group {
-	rwlock.RLock()
-	g := groups[name]
-	rwlock.RUnlock()
-	return g
+
+// Close 关闭 Group：从注册表中移除，取消后台任务，从 MemoryManager 等注销，并释放缓存。
+// 关闭后 Get 返回 ErrGroupClosed，重复调用不做任何事。磁盘二级缓存由调用方负责关闭
+func (g *Group) Close() {
+	if !atomic.CompareAndSwapInt32(&g.closed, 0, 1) {
+		return
+	}
+	if g.registry != nil {
+		g.registry.remove(g)
+	}
+	g.cancel()
+	g.closeLock.Lock()
+	closers := g.closers
+	g.closers = nil
+	g.closeLock.Unlock()
+	for _, f := range closers {
+		f()
+	}
+	g.mainCache.close()
+	g.hotCache.close()
+	g.logger.Info("group closed", "group", g.name)
+}
+
+// onClose 注册 Close 时调用的函数，已经关闭时立即调用
+func (g *Group) onClose(f func()) {
+	g.closeLock.Lock()
+	if atomic.LoadInt32(&g.closed) == 0 {
+		g.closers = append(g.closers, f)
+		g.closeLock.Unlock()
+		return
+	}
+	g.closeLock.Unlock()
+	f()
+}
+
+// Name 返回缓存空间的名字
+func (g *Group) Name() string {
+	return g.name
 }
 
 // Get value for a key from cache
 
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同，ctx 用于传递追踪上下文，以及取消发往远程节点的请求
+func (g *Group) GetContext(ctx context.Context, key string) (value ByteView, err error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
-	if v, ok := g.mainCache.get(key); ok { //从 mainCache 中查找缓存
-		log.Println("[GeeCache]hit")
+	if atomic.LoadInt32(&g.closed) == 1 {
+		return ByteView{}, ErrGroupClosed
+	}
+	ctx, span := g.tracer.Start(ctx, "geecache.Group.Get")
+	span.SetAttributes(Attr("group", g.name), Attr("key_hash", keyHash(key)))
+	defer func() { endSpan(span, err) }()
+
+	g.metrics.gets.Add(1)
+	//刚成为热点 key 时，拿到值后推送到其他节点
+	hot := g.hotKeys != nil && g.hotKeys.offer(key, g.hotThreshold)
+	if v, ok := g.lookupCache(key); ok { //从 mainCache 中查找缓存
+		g.metrics.hits.Add(1)
+		span.SetAttributes(Attr("cache_hit", true))
+		g.logger.Debug("cache hit", "group", g.name, "key_hash", keyHash(key))
+		if hot {
+			go g.promote(key, v)
+		}
 		return v, nil
 	}
+	g.metrics.misses.Add(1)
+	span.SetAttributes(Attr("cache_hit", false))
 	//如果缓存中没有，调用load方法
-	return g.load(key)
+	value, err = g.load(ctx, key)
+	if err == nil && hot {
+		go g.promote(key, value)
+	}
+	return value, err
+}
+
+// lookupCache 依次查找主缓存和热点缓存
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if v, ok := g.mainCache.get(key); ok {
+		return v, true
+	}
+	if v, ok := g.hotCache.get(key); ok {
+		g.metrics.hotHits.Add(1)
+		return v, true
+	}
+	return ByteView{}, false
+}
+
+// SetLogger 设置 Group 使用的日志，传入 nil 表示不输出日志
+func (g *Group) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	g.logger = l
+}
+
+// SetTracer 设置 Group 使用的追踪钩子，传入 nil 表示不追踪
+func (g *Group) SetTracer(t Tracer) {
+	if t == nil {
+		t = nopTracer{}
+	}
+	g.tracer = t
+}
+
+// Invalidate 删除 key 的缓存值，下次 Get 时重新加载。
+// 先删除本节点的主缓存、热点缓存和磁盘二级缓存，再通知所有其他节点删除它们的副本和热点缓存，
+// 有节点通知失败时返回错误，该节点上的旧值仍可能被读到
+func (g *Group) Invalidate(key string) error {
+	if err := g.invalidateLocally(key); err != nil {
+		return err
+	}
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for _, peer := range lister.ListPeers() {
+		invalidator, ok := peer.(PeerInvalidator)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(g.ctx, replicateTimeout)
+		err := invalidator.Invalidate(ctx, &pb.Request{Group: g.name, Key: key})
+		cancel()
+		if err != nil {
+			g.logger.Warn("failed to invalidate on peer", "group", g.name, "key_hash", keyHash(key), "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// invalidateLocally 只删除本节点上 key 的缓存值，收到其他节点广播的失效时使用
+func (g *Group) invalidateLocally(key string) error {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	if g.disk != nil {
+		return g.disk.Delete(key)
+	}
+	return nil
+}
+
+// SetDiskTier 为 Group 开启磁盘二级缓存：内存淘汰的缓存项写入 store，
+// 内存未命中时先查磁盘，再找远程节点或调用 Getter
+func (g *Group) SetDiskTier(store *disk.Store) {
+	g.disk = store
+	g.mainCache.onEvicted = func(key string, value ByteView) {
+		if err := store.Put(key, value.b); err != nil {
+			g.logger.Warn("failed to demote to disk", "group", g.name, "key_hash", keyHash(key), "err", err)
+		}
+	}
+}
+
+// SetCacheBytes 在运行时修改主缓存的容量，热点缓存随之保持为主缓存的 1/hotCacheRatio。
+// 缩小时淘汰最久未访问的缓存项直到不超过新的容量，扩大时保留所有缓存项；
+// 注册到 MemoryManager 的 Group 的容量由管理器决定，下次调整预算时会覆盖这里的设置
+func (g *Group) SetCacheBytes(cacheBytes int64) {
+	g.mainCache.resize(cacheBytes)
+	g.hotCache.resize(hotCacheBytes(cacheBytes))
 }
 
 // 新增RegisterPeers()方法,实现了 PeerPicker 接口的 HTTPPool 注入到 Group 中。
@@ -108,20 +294,60 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 
 // 修改 load 方法，使用 PickPeer() 方法选择节点，若非本机节点，则调用 getFromPeer() 从远程获取。若是本机节点或失败，则回退到 getLocally()。
 // 修改 load 函数，将原来的 load 的逻辑，使用 g.loader.Do 包裹起来即可，这样确保了并发场景下针对相同的 key，load 过程只会调用一次。
-func (g *Group) load(key string) (value ByteView, err error) {
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	ctx, span := g.tracer.Start(ctx, "geecache.Group.load")
+	defer func() { endSpan(span, err) }()
+
+	g.metrics.loads.Add(1)
+	caller := ctx
+	ctx = loadContext{Context: g.ctx, values: ctx}
+	//只有真正执行加载的调用者会进入下面的函数，其余的是被合并的，shared 为 true。
+	//每个调用者只按自己的 ctx 放弃等待，共享的加载使用 loadContext，不会因为发起者取消而让其他调用者失败
+	viewi, err, shared := g.loader.DoContext(caller, key, func() (interface{}, error) {
+		if value, ok := g.getFromDisk(key); ok {
+			return value, nil
+		}
+		forwarded := isForwarded(ctx)
+		var owners []PeerGetter
 		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
+			owners = g.owners(key)
+		}
+		//依次询问副本节点，第一个正常返回的即为结果
+		for i, peer := range owners {
+			if peer == nil { //轮到本节点：本地加载，再写入后面的副本
+				value, err := g.getLocally(ctx, key)
+				if err == nil && i+1 < len(owners) {
+					go g.replicate(key, value, owners[i+1:])
 				}
-				log.Println("[GeeCache] Failed to get from peer", err)
-
+				return value, err
 			}
+			if forwarded { //请求已经由其他节点选过节点，只在本地加载
+				continue
+			}
+			//fn 可能在调用者返回后继续执行，不能写 load 的返回值
+			value, err := g.getFromPeer(ctx, peer, key)
+			if err == nil {
+				g.metrics.peerLoads.Add(1)
+				return value, nil
+			}
+			g.metrics.peerErrs.Add(1)
+			g.logger.Warn("failed to get from peer", "group", g.name, "key_hash", keyHash(key), "err", err)
+			if errors.Is(err, ErrOverloaded) { //负责节点过载：把错误交给调用方退避，不在本地加载
+				return nil, err
+			}
+
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 
 	})
+	span.SetAttributes(Attr("singleflight_shared", shared)) //为 true 时耗时都花在等待其他调用者的加载上
+	var pe *singleflight.PanicError
+	if errors.As(err, &pe) && !shared { //调用栈只写日志，不随错误返回给客户端
+		g.logger.Error("load panicked", "group", g.name, "key_hash", keyHash(key), "err", err, "stack", string(pe.Stack))
+	}
+	if shared {
+		g.metrics.dedups.Add(1)
+	}
 	if err == nil {
 		return viewi.(ByteView), nil
 	}
@@ -129,32 +355,99 @@ func (g *Group) load(key string) (value ByteView, err error) {
 
 }
 
+// loadContext 共享加载使用的上下文：保留发起者的值（追踪上下文、转发标记），
+// 取消只来自 Group 的 ctx，即 Group 关闭时才取消
+type loadContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c loadContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
 // getLocally 调用用户回调函数 g.getter.Get() 获取源数据，并且将源数据添加到缓存 mainCache 中
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (_ ByteView, err error) {
+	_, span := g.tracer.Start(ctx, "geecache.Group.getLocally")
+	defer func() { endSpan(span, err) }()
+
+	if err = g.limiter.acquire(ctx); err != nil { //限制同时调用 Getter 的数量
+		if err == ErrOverloaded {
+			g.metrics.overloaded.Add(1)
+			g.logger.Warn("load rejected", "group", g.name, "key_hash", keyHash(key), "err", err)
+		}
+		return ByteView{}, err
+	}
+	defer g.limiter.release()
+	if err = g.breaker.allow(); err != nil { //数据源故障时快速失败
+		g.metrics.localErrs.Add(1)
+		return ByteView{}, err
+	}
+	returned := false
+	defer func() {
+		if !returned {
+			g.breaker.record(errGetterAborted)
+		}
+	}()
+	start := time.Now()
 	bytes, err := g.getter.Get(key) //如果缓存中没有，就是用回调结构体中的Get方法获取指定键的源数据
+	returned = true
+	g.breaker.record(err)
+	latency := time.Since(start)
+	g.metrics.getterLatency.Observe(latency)
 	if err != nil {
+		g.metrics.localErrs.Add(1)
+		g.logger.Debug("getter failed", "group", g.name, "key_hash", keyHash(key), "latency", latency, "err", err)
 		return ByteView{}, err
 	}
+	g.logger.Debug("loaded from getter", "group", g.name, "key_hash", keyHash(key), "latency", latency)
+	g.metrics.localLoads.Add(1)
 	value := ByteView{b: cloneBytes(bytes)}
 	g.populateCache(key, value)
 	return value, nil
 
 }
+
+// getFromDisk 从磁盘二级缓存读取，命中后放回内存
+func (g *Group) getFromDisk(key string) (ByteView, bool) {
+	if g.disk == nil {
+		return ByteView{}, false
+	}
+	b, ok, err := g.disk.Get(key)
+	if err != nil {
+		g.logger.Warn("failed to read from disk", "group", g.name, "key_hash", keyHash(key), "err", err)
+	}
+	if !ok {
+		return ByteView{}, false
+	}
+	g.metrics.diskHits.Add(1)
+	value := ByteView{b: b}
+	g.populateCache(key, value)
+	return value, true
+}
+
 func (g *Group) populateCache(key string, value ByteView) {
 	g.mainCache.add(key, value)
 }
 
 // 实现 PeerGetter 接口的 httpGetter 从访问远程节点，获取缓存值。
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (_ ByteView, err error) {
+	ctx, span := g.tracer.Start(ctx, "geecache.Group.getFromPeer")
+	defer func() { endSpan(span, err) }()
+
 	//bytes, err := peer.Get(g.name, key)
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	start := time.Now()
+	err = peer.Get(ctx, req, res)
+	latency := time.Since(start)
+	g.metrics.peerLatency.Observe(latency)
+	g.logger.Debug("peer call", "group", g.name, "key_hash", keyHash(key), "latency", latency, "err", err)
 	if err != nil {
-		return ByteView{}, nil
+		return ByteView{}, err
 	}
 	return ByteView{b: res.Value}, nil
 
@@ -1,10 +1,26 @@
 package geecache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"geecache/disk"
+	pb "geecache/geecachepb"
+	"geecache/lru"
 	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // 测试回调函数
@@ -61,3 +77,647 @@ func TestGet(t *testing.T) {
 	}
 
 }
+
+// 测试指标：命中、未命中以及 Prometheus 文本输出
+func TestMetrics(t *testing.T) {
+	gee := NewGroup("metrics", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`geecache_hits_total{group="metrics"} 1`,
+		`geecache_misses_total{group="metrics"} 1`,
+		`geecache_local_loads_total{group="metrics"} 1`,
+		`geecache_cache_items{group="metrics"} 1`,
+		`geecache_getter_latency_seconds_count{group="metrics"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics output missing %q", line)
+		}
+	}
+}
+
+// 测试 Stats 快照
+func TestStats(t *testing.T) {
+	gee := NewGroup("stats", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("unknown")
+
+	stats := gee.Stats()
+	if stats.Gets != 3 || stats.Hits != 1 || stats.Misses != 2 || stats.LocalLoads != 1 || stats.LocalLoadErrs != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if cs := gee.CacheStats(); cs.Items != 1 || cs.Bytes != int64(len("Tom")+len(db["Tom"]))+lru.EntryOverhead {
+		t.Fatalf("unexpected cache stats %+v", cs)
+	}
+}
+
+// 测试日志：低于设定级别的日志不输出，字段以 key=value 形式输出
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	gee := NewGroup("logger", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.SetLogger(logger)
+	gee.Get("Tom")
+	gee.Get("Tom")
+	if buf.Len() != 0 {
+		t.Fatalf("debug logs should be dropped at info level, got %q", buf.String())
+	}
+	logger.Warn("failed to get from peer", "group", "logger", "peer", "http://localhost:8001")
+	if expect := "[GeeCache] WARN failed to get from peer group=logger peer=http://localhost:8001\n"; buf.String() != expect {
+		t.Fatalf("expect %q, got %q", expect, buf.String())
+	}
+}
+
+// 测试快照：恢复后内容和 LRU 顺序不变，损坏的快照被拒绝
+func TestSnapshot(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	src := NewGroup("snapshot", 2<<10, getter)
+	for _, k := range []string{"Tom", "Jack", "Sam"} {
+		src.Get(k)
+	}
+	src.Get("Tom") //Tom 变为最近访问
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	dst := NewGroup("snapshot-restore", 2<<10, getter)
+	if err := dst.Restore(bytes.NewReader(data)); err == nil {
+		t.Fatalf("restoring a snapshot of another group should fail")
+	}
+	dst.name = "snapshot" //只用于测试：让名字一致
+	if err := dst.Restore(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, e := range dst.mainCache.entries() {
+		keys = append(keys, e.key+"="+e.value.String())
+	}
+	if expect := []string{"Jack=Jack", "Sam=Sam", "Tom=Tom"}; !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("expect %v, got %v", expect, keys)
+	}
+
+	data[len(data)/2] ^= 0xff
+	if err := dst.Restore(bytes.NewReader(data)); err == nil {
+		t.Fatalf("corrupt snapshot should be rejected")
+	}
+}
+
+// 测试磁盘二级缓存：内存中被淘汰的缓存项从磁盘读回，不再调用 Getter
+func TestDiskTier(t *testing.T) {
+	loads := 0
+	gee := NewGroup("disk", int64(len("Tom")+len("630")), GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	store, err := disk.Open(filepath.Join(t.TempDir(), "disk.log"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	gee.SetDiskTier(store)
+
+	gee.Get("Tom")
+	gee.Get("Sam") //内存只能放一项，Tom 被降级到磁盘
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("get Tom from disk = %q, %v", view, err)
+	}
+	if loads != 2 || gee.Stats().DiskHits != 1 {
+		t.Fatalf("expect 2 getter loads and 1 disk hit, got %d loads, %d disk hits", loads, gee.Stats().DiskHits)
+	}
+}
+
+// ownerPicker 只把 remote 中的 key 分配给远程节点
+type ownerPicker struct{ remote map[string]bool }
+
+func (p ownerPicker) PickPeer(key string) (PeerGetter, bool) {
+	return nil, p.remote[key]
+}
+
+// 测试预热：只加载本节点负责的 key，并报告进度
+func TestPreload(t *testing.T) {
+	gee := NewGroup("preload", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	gee.RegisterPeers(ownerPicker{remote: map[string]bool{"Jack": true}})
+
+	calls := 0
+	p, err := gee.Preload(context.Background(), []string{"Tom", "Jack", "Sam", "unknown"}, 2, func(PreloadProgress) { calls++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := (PreloadProgress{Total: 4, Skipped: 1, Loaded: 2, Failed: 1}); p != expect || calls != 4 {
+		t.Fatalf("expect progress %+v with 4 reports, got %+v with %d", expect, p, calls)
+	}
+	if cs := gee.CacheStats(); cs.Items != 2 {
+		t.Fatalf("expect 2 warmed items, got %d", cs.Items)
+	}
+}
+
+// replicaPeer 模拟一个远程副本节点
+type replicaPeer struct {
+	mu     sync.Mutex
+	value  string //不为空时 Get 返回它，否则返回错误（节点宕机）
+	stored map[string]string
+}
+
+func (p *replicaPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if p.value == "" {
+		return fmt.Errorf("peer down")
+	}
+	out.Value = []byte(p.value)
+	return nil
+}
+
+func (p *replicaPeer) Set(ctx context.Context, in *pb.Request, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stored[in.Key] = string(value)
+	return nil
+}
+
+type replicaPicker []PeerGetter
+
+func (p replicaPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p[0], p[0] != nil
+}
+
+func (p replicaPicker) PickReplicas(key string, n int) []PeerGetter {
+	return p[:n]
+}
+
+// 测试副本：依次读取副本，负责节点宕机时本节点加载并写入后面的副本
+func TestReplicas(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(db[key]), nil
+	})
+	down := &replicaPeer{stored: map[string]string{}}
+	next := &replicaPeer{stored: map[string]string{}}
+	gee := NewGroup("replicas", 2<<10, getter)
+	gee.SetReplicas(3)
+	gee.RegisterPeers(replicaPicker{down, nil, next})
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "630" || loads != 1 {
+		t.Fatalf("get Tom = %q, %v, %d loads", v, err, loads)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		next.mu.Lock()
+		stored := next.stored["Tom"]
+		next.mu.Unlock()
+		if stored == "630" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Tom not replicated to the next replica")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(down.stored) != 0 {
+		t.Fatalf("replica before self should not be written")
+	}
+
+	//第一个副本正常时直接使用它的结果
+	gee2 := NewGroup("replicas-healthy", 2<<10, getter)
+	gee2.SetReplicas(2)
+	gee2.RegisterPeers(replicaPicker{&replicaPeer{value: "remote"}, nil})
+	if v, err := gee2.Get("Tom"); err != nil || v.String() != "remote" || loads != 1 {
+		t.Fatalf("get Tom from first replica = %q, %v, %d loads", v, err, loads)
+	}
+}
+
+// 测试 Getter 发生 panic：Get 返回错误而不是让进程崩溃，之后的 Get 重新加载
+func TestGetterPanic(t *testing.T) {
+	fail := true
+	gee := NewGroup("panic", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if fail {
+				panic("getter bug")
+			}
+			return []byte(key), nil
+		}))
+	if _, err := gee.Get("Tom"); err == nil || !strings.Contains(err.Error(), "getter bug") {
+		t.Fatalf("expect panic error, got %v", err)
+	}
+	fail = false
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("get after panic = %q, %v", v, err)
+	}
+}
+
+// 测试 Getter 调用 runtime.Goexit：调用者的协程退出，之后的 Get 不会一直等待
+func TestGetterGoexit(t *testing.T) {
+	exit := true
+	gee := NewGroup("goexit", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if exit {
+				runtime.Goexit()
+			}
+			return []byte(key), nil
+		}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gee.Get("Tom")
+	}()
+	<-done
+	exit = false
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("get after Goexit = %q, %v", v, err)
+	}
+}
+
+// 测试 Getter 熔断：失败率超过阈值后快速失败，超时后半开，试探成功后关闭
+func TestBreaker(t *testing.T) {
+	calls, fail := 0, true
+	gee := NewGroup("breaker", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			calls++
+			if fail {
+				return nil, fmt.Errorf("db down")
+			}
+			return []byte(key), nil
+		}))
+	gee.SetBreaker(&BreakerOptions{MinRequests: 4, FailureRatio: 0.5, OpenTimeout: 50 * time.Millisecond})
+	for i := 0; i < 4; i++ {
+		gee.Get(fmt.Sprintf("key-%d", i))
+	}
+	if gee.BreakerState() != BreakerOpen {
+		t.Fatalf("breaker should be open after 4 failures, got %v", gee.BreakerState())
+	}
+	if _, err := gee.Get("Tom"); err != ErrBreakerOpen || calls != 4 {
+		t.Fatalf("open breaker should fail fast, got %v after %d calls", err, calls)
+	}
+	if gs := localStats("", defaultRegistry.Groups()).Groups["breaker"]; gs.Breaker != "open" {
+		t.Fatalf("stats should report open breaker, got %q", gs.Breaker)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	fail = false
+	if gee.BreakerState() != BreakerHalfOpen {
+		t.Fatalf("breaker should be half-open after timeout, got %v", gee.BreakerState())
+	}
+	if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" || gee.BreakerState() != BreakerClosed {
+		t.Fatalf("successful probe should close the breaker, got %q, %v, %v", v, err, gee.BreakerState())
+	}
+}
+
+// 测试加载限流：并发加载和排队都满时返回 ErrOverloaded
+func TestLoadLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	gee := NewGroup("limit", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			started <- struct{}{}
+			<-release
+			return []byte(key), nil
+		}))
+	gee.SetLoadLimit(1, 1)
+
+	errs := make(chan error, 2)
+	go func() { _, err := gee.Get("Tom"); errs <- err }()
+	<-started //Tom 占用唯一的空位
+	go func() { _, err := gee.Get("Jack"); errs <- err }()
+	for atomic.LoadInt64(&gee.limiter.waiting) != 1 { //等待 Jack 进入队列
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := gee.Get("Sam"); err != ErrOverloaded {
+		t.Fatalf("expect ErrOverloaded, got %v", err)
+	}
+	rec := httptest.NewRecorder() //节点请求返回 503 和 Retry-After，调用方退避重试，不会在本地加载
+	NewHTTPPool("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultBasePath+"limit/Sam", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("overloaded peer request got %d", rec.Code)
+	}
+	if gee.Stats().Overloaded != 2 {
+		t.Fatalf("expect 2 overloaded loads, got %d", gee.Stats().Overloaded)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued load failed: %v", err)
+		}
+	}
+}
+
+// 测试排队超时：调用者都已离开的加载等待超过 maxWait 后放弃，空位空出来之后不会再调用 Getter
+func TestLoadQueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var jackLoads int32
+	gee := NewGroup("queue-timeout", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if key == "Jack" {
+				atomic.AddInt32(&jackLoads, 1)
+				return []byte(key), nil
+			}
+			<-release
+			return []byte(key), nil
+		}))
+	gee.SetLoadLimit(1, 1)
+	gee.limiter.maxWait = 50 * time.Millisecond
+
+	go gee.Get("Tom") //Tom 占用唯一的空位
+	for len(gee.limiter.slots) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := gee.GetContext(ctx, "Jack"); err != context.DeadlineExceeded {
+		t.Fatalf("expect caller to give up with DeadlineExceeded, got %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&gee.limiter.waiting) != 0 { //Jack 的加载排队超时后离开队列
+		if time.Now().After(deadline) {
+			t.Fatal("queued load should give up after maxWait")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&jackLoads); n != 0 {
+		t.Fatalf("abandoned load should not call getter, got %d calls", n)
+	}
+	if gee.Stats().Overloaded != 1 {
+		t.Fatalf("expect the timed out load to count as overloaded, got %d", gee.Stats().Overloaded)
+	}
+}
+
+// 测试内存管理：容量从空闲的 Group 移到能多命中的 Group，总预算不变，高优先级的 Group 不低于下限
+func TestMemoryManager(t *testing.T) {
+	value := strings.Repeat("v", 100)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	})
+	busy := NewGroup("memory-busy", 1<<20, getter)
+	idle := NewGroup("memory-idle", 1<<20, getter)
+	const total = 64 << 10
+	m := NewMemoryManager(total)
+	m.Register(busy, 1)
+	m.Register(idle, 3)
+	if b := m.Budgets(); b[busy] != total/4 || b[idle] != total*3/4 {
+		t.Fatalf("budgets should follow weights, got %v", b)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 1000; i++ { //工作集大于 busy 的容量
+			busy.Get(fmt.Sprintf("key-%d", r.Intn(400)))
+		}
+		m.Rebalance()
+	}
+	b := m.Budgets()
+	if b[busy] <= total/4 {
+		t.Fatalf("busy group should grow, got %v", b)
+	}
+	if b[idle] < total*3/4/2 {
+		t.Fatalf("idle group should keep its floor, got %v", b)
+	}
+	if b[busy]+b[idle] != total {
+		t.Fatalf("budgets should add up to %d, got %v", total, b)
+	}
+	if bytes := busy.CacheStats().Bytes; bytes > b[busy] {
+		t.Fatalf("busy group holds %d bytes over its budget %d", bytes, b[busy])
+	}
+
+	m.Unregister(busy)
+	if b := m.Budgets(); len(b) != 1 || b[idle] != total {
+		t.Fatalf("remaining group should get the whole budget, got %v", b)
+	}
+}
+
+// 测试内存压力控制：占用超过上限的 High 比例时收缩容量，回落后恢复，没有上限时不调整
+func TestPressureController(t *testing.T) {
+	g := NewGroup("pressure", 10000, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	managed := NewGroup("pressure-managed", 1<<20, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	m := NewMemoryManager(20000)
+	m.Register(managed, 1)
+
+	c := NewPressureController(&PressureOptions{ShrinkRatio: 0.5, MinScale: 0.2})
+	c.Watch(g)
+	c.WatchManager(m)
+	var used, limit int64 = 90, 100
+	c.usage = func() (int64, int64) { return used, limit }
+
+	c.Check()
+	if s := g.CacheStats(); s.MaxBytes != 5000 {
+		t.Fatalf("expect cache to shrink to 5000 bytes, got %d", s.MaxBytes)
+	}
+	if b := m.Budgets(); b[managed] != 10000 {
+		t.Fatalf("expect managed budget to shrink to 10000, got %v", b)
+	}
+	c.Check()
+	c.Check()
+	if s := g.CacheStats(); s.MaxBytes != 2000 || c.Scale() != 0.2 {
+		t.Fatalf("expect cache to stop at MinScale, got %d", s.MaxBytes)
+	}
+
+	used = 50
+	for i := 0; i < 5; i++ {
+		c.Check()
+	}
+	if s := g.CacheStats(); s.MaxBytes != 10000 || m.Budgets()[managed] != 20000 {
+		t.Fatalf("expect capacity to recover, got %d and %v", s.MaxBytes, m.Budgets())
+	}
+
+	used, limit = 90, math.MaxInt64 //没有设置内存上限
+	c.Check()
+	if c.Scale() != 1 {
+		t.Fatalf("expect no change without a memory limit, got scale %v", c.Scale())
+	}
+	if used, _ := runtimeMemory(); used <= 0 {
+		t.Fatalf("expect runtime memory usage, got %d", used)
+	}
+}
+
+// 测试 Group 的生命周期：重名报错、显式替换、关闭后释放缓存并注销，以及互相隔离的注册表
+func TestRegistry(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	first := NewGroup("lifecycle", 2<<10, getter)
+	old := NewGroup("lifecycle", 2<<10, getter) //同名时覆盖，旧 Group 不关闭
+	if GetGroup("lifecycle") != old {
+		t.Fatalf("NewGroup with a duplicate name should overwrite")
+	}
+	if _, err := first.Get("Tom"); err != nil {
+		t.Fatalf("overwritten group should keep working, got %v", err)
+	}
+	old.Get("Tom")
+
+	m := NewMemoryManager(64 << 10)
+	m.Register(old, 1)
+	g := ReplaceGroup("lifecycle", 2<<10, getter)
+	if GetGroup("lifecycle") != g {
+		t.Fatalf("ReplaceGroup should register the new group")
+	}
+	if _, err := old.Get("Tom"); err != ErrGroupClosed {
+		t.Fatalf("replaced group should be closed, got %v", err)
+	}
+	if s := old.CacheStats(); s.Items != 0 {
+		t.Fatalf("closed group should free its cache, got %d items", s.Items)
+	}
+	if len(m.Budgets()) != 0 {
+		t.Fatalf("closed group should leave the memory manager, got %v", m.Budgets())
+	}
+	old.Close() //重复关闭不做任何事
+	if GetGroup("lifecycle") != g {
+		t.Fatalf("closing the replaced group should not remove the new one")
+	}
+	if !DeleteGroup("lifecycle") || DeleteGroup("lifecycle") || GetGroup("lifecycle") != nil {
+		t.Fatalf("DeleteGroup should remove the group once")
+	}
+
+	//互相隔离的注册表
+	r := NewRegistry()
+	tenant, err := r.NewGroup("tenant", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewGroup("tenant", 2<<10, getter); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expect ErrGroupExists, got %v", err)
+	}
+	if GetGroup("tenant") != nil {
+		t.Fatalf("groups of another registry should not be visible globally")
+	}
+	pool := NewHTTPPool("")
+	pool.SetRegistry(r)
+	rec := httptest.NewRecorder()
+	pool.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultBasePath+"tenant/Tom", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pool should serve groups of its registry, got %d", rec.Code)
+	}
+
+	//不同注册表中的同名 Group 在内存管理器中互不影响
+	other, err := NewRegistry().NewGroup("tenant", 2<<10, getter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = NewMemoryManager(64 << 10)
+	m.Register(tenant, 1)
+	m.Register(other, 1)
+	if b := m.Budgets(); len(b) != 2 || b[tenant] != 32<<10 || b[other] != 32<<10 {
+		t.Fatalf("same-name groups should be managed separately, got %v", b)
+	}
+	other.Close()
+	if b := m.Budgets(); len(b) != 1 || b[tenant] != 64<<10 {
+		t.Fatalf("closing one group should not unregister the other, got %v", b)
+	}
+}
+
+// 测试 Space-Saving：满了之后新 key 替换计数最小的 key 并继承其计数作为误差，减半后计数为 0 的 key 不再跟踪
+func TestTopK(t *testing.T) {
+	tk := newTopK(3)
+	for key, n := range map[string]int{"a": 5, "b": 3, "c": 1} {
+		for i := 0; i < n; i++ {
+			tk.offer(key, 0)
+		}
+	}
+	tk.offer("d", 0)
+	want := []HotKey{{Key: "a", Count: 5}, {Key: "b", Count: 3}, {Key: "d", Count: 2, Error: 1}}
+	if got := tk.top(0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("top = %v, want %v", got, want)
+	}
+	if !tk.offer("b", 4) || tk.offer("b", 4) {
+		t.Fatalf("b should be promoted exactly once")
+	}
+	tk.offer("e", 0) //替换 d
+	tk.decay()
+	want = []HotKey{{Key: "a", Count: 2}, {Key: "b", Count: 2}, {Key: "e", Count: 1, Error: 1}}
+	if got := tk.top(0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("top after decay = %v, want %v", got, want)
+	}
+}
+
+// 测试共享加载：发起加载的调用者取消后，等待同一个 key 的其他调用者照常拿到结果，数据源只调用一次
+func TestLoadCancel(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	gee := NewGroup("load-cancel", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return []byte(key), nil
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := gee.GetContext(ctx, "Tom")
+		first <- err
+	}()
+	for atomic.LoadInt32(&loads) == 0 { //等待第一个调用者开始加载
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error, 1)
+	go func() {
+		v, err := gee.Get("Tom")
+		if err == nil && v.String() != "Tom" {
+			err = fmt.Errorf("got %q", v.String())
+		}
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled caller got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("waiting caller failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expect 1 load, got %d", n)
+	}
+}
+
+// 测试热点缓存容量：主缓存小于 hotCacheRatio 字节时热点缓存容量为 1，而不是 0（不限容量）
+func TestHotCacheBytes(t *testing.T) {
+	g := NewGroup("hot-bytes", 4, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	if g.hotCache.cacheBytes != 1 {
+		t.Fatalf("expect hot cache limit 1, got %d", g.hotCache.cacheBytes)
+	}
+	g.SetCacheBytes(64)
+	g.SetCacheBytes(7) //在线缩小到 hotCacheRatio 以下
+	if _, max, _, _ := g.hotCache.stats(); max != 1 {
+		t.Fatalf("expect hot cache limit 1 after resize, got %d", max)
+	}
+	if n := hotCacheBytes(0); n != 0 {
+		t.Fatalf("unlimited main cache should keep an unlimited hot cache, got %d", n)
+	}
+}
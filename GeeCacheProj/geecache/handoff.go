@@ -0,0 +1,87 @@
+package geecache
+
+/*
+节点变更时的缓存交接：HTTPPool.Set 改变了节点列表后，部分 key 在新的一致性哈希下归属了新节点，
+新节点的缓存是空的，这些 key 的请求会全部打到数据源上。
+原负责节点把这些缓存项按从热到冷的顺序、限速推送给新的负责节点，避免扩缩容看起来像一次冷启动
+*/
+
+import (
+	"context"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"time"
+)
+
+const defaultHandoffRate = 1000 //每秒最多交接的缓存项数量
+
+// SetHandoffRate 设置节点变更时每秒最多交接的缓存项数量，0 表示不交接
+func (p *HTTPPool) SetHandoffRate(perSecond int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.handoffRate = perSecond
+}
+
+// handoffEntry 一条需要交接的缓存项
+type handoffEntry struct {
+	group  string
+	key    string
+	value  ByteView
+	target *httpGetter
+}
+
+// startHandoff 在 Set 持有锁时调用，old 为变更前的节点放置。交接在后台进行，新的变更会取消上一次交接
+func (p *HTTPPool) startHandoff(old consistenthash.Placement) {
+	if p.handoffCancel != nil {
+		p.handoffCancel()
+		p.handoffCancel = nil
+	}
+	if p.handoffRate <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.handoffCancel = cancel
+	go p.handoff(ctx, old, p.peers, p.httpGetters, p.handoffRate)
+}
+
+func (p *HTTPPool) handoff(ctx context.Context, old, cur consistenthash.Placement, getters map[string]*httpGetter, rate int) {
+	var moved []handoffEntry
+	for _, g := range p.registry.Groups() {
+		if g.peers != PeerPicker(p) {
+			continue
+		}
+		entries := g.mainCache.entries()
+		for i := len(entries) - 1; i >= 0; i-- { //最近访问的在最后，先交接最热的
+			e := entries[i]
+			if old.Get(e.key) != p.self {
+				continue
+			}
+			if owner := cur.Get(e.key); owner != p.self && getters[owner] != nil {
+				moved = append(moved, handoffEntry{group: g.name, key: e.key, value: e.value, target: getters[owner]})
+			}
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	p.logger.Info("handoff started", "entries", len(moved))
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	sent, failed := 0, 0
+	for _, e := range moved {
+		select {
+		case <-ctx.Done():
+			p.logger.Info("handoff cancelled", "sent", sent, "failed", failed)
+			return
+		case <-ticker.C:
+		}
+		if err := e.target.Set(ctx, &pb.Request{Group: e.group, Key: e.key}, e.value.b); err != nil {
+			failed++
+			p.logger.Debug("handoff failed", "group", e.group, "key_hash", keyHash(e.key), "peer", e.target.baseURL, "err", err)
+			continue
+		}
+		sent++
+	}
+	p.logger.Info("handoff finished", "sent", sent, "failed", failed)
+}
@@ -0,0 +1,219 @@
+package geecache
+
+/*
+热点 key：每个 Group 用 Space-Saving 算法统计访问最多的 K 个 key，只占 O(K) 内存，
+计数每个窗口减半，反映最近一段时间的热度。
+负责节点发现某个 key 的访问次数超过阈值后，把缓存值推送到所有节点的热点缓存（hotCache），
+之后其他节点直接在本地命中，不会把请求都打到负责节点上
+*/
+
+import (
+	"container/heap"
+	"context"
+	pb "geecache/geecachepb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hotWindow     = time.Minute //计数减半的周期
+	hotCacheRatio = 8           //热点缓存的容量为主缓存的 1/8
+)
+
+// hotCacheBytes 返回主缓存容量为 cacheBytes 时热点缓存的容量。
+// 至少为 1，避免主缓存很小时整除得到 0，热点缓存变成不限容量；主缓存不限容量（0）时热点缓存也不限
+func hotCacheBytes(cacheBytes int64) int64 {
+	if cacheBytes <= 0 {
+		return 0
+	}
+	if n := cacheBytes / hotCacheRatio; n > 0 {
+		return n
+	}
+	return 1
+}
+
+// HotKey 一个热点 key 的统计
+type HotKey struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"` //估计的访问次数，是真实值的上界
+	Error int64  `json:"error"` //估计误差，Count-Error 是真实值的下界
+}
+
+type hotEntry struct {
+	HotKey
+	promoted bool //本窗口内已推送到其他节点
+	index    int  //在最小堆中的下标
+}
+
+// hotHeap 按计数排序的最小堆，堆顶是计数最小的 key，实现 heap.Interface
+type hotHeap []*hotEntry
+
+func (h hotHeap) Len() int           { return len(h) }
+func (h hotHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h hotHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotHeap) Push(x interface{}) {
+	e := x.(*hotEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *hotHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// topK Space-Saving 算法：最多跟踪 k 个 key，满了之后新 key 替换计数最小的 key，并继承它的计数作为误差。
+// 计数最小的 key 由最小堆维护，每次访问 O(log k)
+type topK struct {
+	lock    sync.Mutex
+	k       int
+	entries map[string]*hotEntry
+	heap    hotHeap
+	decayed time.Time //上次减半的时间
+}
+
+func newTopK(k int) *topK {
+	return &topK{k: k, entries: make(map[string]*hotEntry, k), heap: make(hotHeap, 0, k), decayed: time.Now()}
+}
+
+// offer 记录一次访问。访问次数的下界第一次达到 threshold 时返回 true，threshold <= 0 表示不推送
+func (t *topK) offer(key string, threshold int64) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if time.Since(t.decayed) >= hotWindow {
+		t.decay()
+	}
+	e, ok := t.entries[key]
+	if !ok {
+		if len(t.entries) < t.k {
+			e = &hotEntry{HotKey: HotKey{Key: key}}
+			heap.Push(&t.heap, e)
+		} else { //复用堆顶计数最小的项
+			e = t.heap[0]
+			delete(t.entries, e.Key)
+			e.HotKey = HotKey{Key: key, Count: e.Count, Error: e.Count}
+			e.promoted = false
+		}
+		t.entries[key] = e
+	}
+	e.Count++
+	heap.Fix(&t.heap, e.index)
+	if threshold > 0 && !e.promoted && e.Count-e.Error >= threshold {
+		e.promoted = true
+		return true
+	}
+	return false
+}
+
+// decay 计数减半，减到 0 的 key 不再跟踪；新窗口内热点 key 可以再次推送，刷新其他节点的热点缓存
+func (t *topK) decay() {
+	kept := t.heap[:0]
+	for _, e := range t.heap {
+		e.Count /= 2
+		e.Error /= 2
+		e.promoted = false
+		if e.Count == 0 {
+			delete(t.entries, e.Key)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	for i := len(kept); i < len(t.heap); i++ {
+		t.heap[i] = nil
+	}
+	t.heap = kept
+	for i, e := range t.heap {
+		e.index = i
+	}
+	heap.Init(&t.heap) //每个窗口重建一次堆，O(k)
+	t.decayed = time.Now()
+}
+
+// top 返回计数最大的 n 个 key，n <= 0 时返回全部
+func (t *topK) top(n int) []HotKey {
+	t.lock.Lock()
+	keys := make([]HotKey, 0, len(t.entries))
+	for _, e := range t.entries {
+		keys = append(keys, e.HotKey)
+	}
+	t.lock.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if n > 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// SetHotKeys 开启热点 key 统计：跟踪访问最多的 k 个 key，一个窗口内访问次数达到 threshold 的 key
+// 由负责节点推送到所有节点的热点缓存。k <= 0 表示关闭（默认），threshold <= 0 表示只统计不推送
+func (g *Group) SetHotKeys(k int, threshold int64) {
+	if k <= 0 {
+		g.hotKeys = nil
+		return
+	}
+	g.hotKeys = newTopK(k)
+	g.hotThreshold = threshold
+}
+
+// HotKeys 返回访问最多的 n 个 key，未开启统计时返回 nil
+func (g *Group) HotKeys(n int) []HotKey {
+	if g.hotKeys == nil {
+		return nil
+	}
+	return g.hotKeys.top(n)
+}
+
+// promote 本节点是负责节点时，把热点 key 推送到所有其他节点的热点缓存
+func (g *Group) promote(key string, value ByteView) {
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return
+	}
+	if _, remote := g.peers.PickPeer(key); remote {
+		return
+	}
+	g.logger.Info("promote hot key", "group", g.name, "key_hash", keyHash(key))
+	for _, peer := range lister.ListPeers() {
+		setter, ok := peer.(HotSetter)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(g.ctx, replicateTimeout)
+		err := setter.SetHot(ctx, &pb.Request{Group: g.name, Key: key}, value.b)
+		cancel()
+		if err != nil {
+			g.logger.Warn("failed to promote hot key", "group", g.name, "key_hash", keyHash(key), "err", err)
+		}
+	}
+}
+
+// ListPeers 返回所有远程节点
+func (p *HTTPPool) ListPeers() []PeerGetter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	peers := make([]PeerGetter, 0, len(p.addrs))
+	for _, addr := range p.addrs {
+		if addr != p.self {
+			peers = append(peers, p.httpGetters[addr])
+		}
+	}
+	return peers
+}
+
+var _ PeerLister = (*HTTPPool)(nil)
+var _ HotSetter = (*httpGetter)(nil)
+var _ PeerInvalidator = (*httpGetter)(nil)
@@ -7,15 +7,19 @@ package geecache
 */
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"geecache/consistenthash"
 	pb "geecache/geecachepb"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -24,32 +28,88 @@ import (
 
 const defaultBasePath = "/_geecache/"
 
+// 节点之间转发的请求带上这个请求头，接收方直接在本地加载。
+// 有界负载、熔断和对冲都可能把请求发给不负责该 key 的节点，接收方若按自己的视图重新选择，请求会被转回负责节点
+const forwardedHeader = "X-Geecache-Forwarded"
+
 // 作为承载节点间 HTTP 通信的核心数据结构
 type HTTPPool struct {
 	self        string //用来记录自己的地址，包括主机名/IP 和端口。
 	basePath    string //http://example.com/_geecache/ 开头的请求
 	lock        sync.Mutex
-	peers       *consistenthash.Map    //一致性哈希 根据key选择节点
-	httpGetters map[string]*httpGetter //每个远程节点对应一个httpGetter; key： http://10.0.0.2:8008
+	peers       consistenthash.Placement        //一致性哈希 根据key选择节点
+	placement   func() consistenthash.Placement //创建节点放置算法，默认为哈希环
+	addrs       []string                        //所有节点的地址，包括自己
+	httpGetters map[string]*httpGetter          //每个远程节点对应一个httpGetter; key： http://10.0.0.2:8008
+
+	client            *http.Client //访问远程节点使用的客户端，SetTLS 后走 https
+	secret            []byte       //集群共享密钥，非空时对节点请求做 HMAC 签名和校验
+	requireClientCert bool         //mTLS：要求请求携带校验通过的客户端证书
+	authorizer        Authorizer   //按缓存空间做访问控制，为 nil 时不限制
+	logger            Logger       //默认不输出日志
+	audit             Logger       //审计日志，默认输出到标准库的 log
+	nonces            nonceCache   //见过的写请求随机数，拒绝重放
+	tracer            Tracer       //默认不做追踪
+
+	handoffRate   int                //节点变更时每秒最多交接的缓存项数量，0 表示不交接
+	handoffCancel context.CancelFunc //取消尚未完成的上一次交接
+
+	loadEpsilon float64         //大于 0 时按有界负载选择节点，每个节点的负载不超过平均值的 (1+loadEpsilon) 倍
+	breakerOpts *BreakerOptions //不为 nil 时为每个远程节点创建熔断器
+	retry       *RetryPolicy    //不为 nil 时重试失败的节点请求
+	hedge       *HedgePolicy    //不为 nil 时对慢请求发起对冲请求
+	inflight    counter         //本节点正在处理的请求数
+
+	rateLimits map[string]*RateLimiter //按缓存空间对每个远程节点限速，"*" 作用于其他缓存空间
+	registry   *Registry               //查找缓存空间的注册表，默认为全局注册表
 }
 
 // 实例化
 func NewHTTPPool(self string) *HTTPPool {
 	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+		self:        self,
+		basePath:    defaultBasePath,
+		client:      http.DefaultClient,
+		logger:      nopLogger{},
+		audit:       defaultAudit,
+		tracer:      nopTracer{},
+		handoffRate: defaultHandoffRate,
+		placement:   newRing,
+		registry:    defaultRegistry,
 	}
 }
 
+// newRing 默认的节点放置算法：每个真实节点 defaultReplicas 个虚拟节点的哈希环
+func newRing() consistenthash.Placement {
+	return consistenthash.New(defaultReplicas, nil) //复习New参数：每个真实节点有多少个虚拟节点，如果没有自定义哈希函数（nil），就使用默认的
+}
+
+// SetPlacement 设置节点放置算法，例如 consistenthash.NewRendezvous、NewJump、NewMaglev，传入 nil 表示使用默认的哈希环。
+// 需要在 Set 之前调用，集群内所有节点必须使用相同的算法
+func (p *HTTPPool) SetPlacement(newPlacement func() consistenthash.Placement) {
+	if newPlacement == nil {
+		newPlacement = newRing
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.placement = newPlacement
+}
+
 // HTTPPool结构体实现http.Handler接口里的ServeHTTP方法
 // 实现HTTP响应
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	//首先判断访问路径的前缀是否是 basePath，不是返回错误。
 	//r.URL.Path是/_geecache
-	log.Println(r.URL.Path)
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes) //校验签名和写入缓存都要读 body，先限制大小
+	//配置了 TLS 客户端证书或共享密钥时，拒绝未认证的节点请求
+	if err := p.authenticate(r); err != nil {
+		p.logger.Warn("reject unauthenticated request", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, err.Error(), bodyErrorStatus(err, http.StatusUnauthorized))
+		return
+	}
 	//约定访问路径格式为 /<basepath>/<groupname>/<key>
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2) //strings.SplitN将一个给定的字符串按给定的分隔符分割成n个子串
 	//parts=[groupname key]
@@ -59,17 +119,103 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	groupname := parts[0]
 	key := parts[1]
+	p.logger.Debug("serve peer request", "method", r.Method, "group", groupname, "key_hash", keyHash(key), "remote", r.RemoteAddr)
+	p.inflight.Add(1)
+	defer p.inflight.Add(-1)
+	//延续调用方的追踪上下文
+	ctx, span := p.tracer.Start(p.tracer.Extract(r.Context(), r.Header), "geecache.HTTPPool.ServeHTTP")
+	defer span.End()
+	span.SetAttributes(Attr("node", p.self), Attr("group", groupname), Attr("key_hash", keyHash(key)))
+
+	//通过共享密钥认证的请求来自集群内的其他节点
+	fallback := ""
+	if len(p.secret) > 0 {
+		fallback = PeerIdentity
+	}
+	//GET 读取缓存值，PUT 由其他节点写入缓存值（例如节点变更时的交接），DELETE 删除缓存值
+	var op Operation
+	switch r.Method {
+	case http.MethodGet:
+		op = OpRead
+	case http.MethodPut:
+		op = OpWrite
+	case http.MethodDelete:
+		op = OpInvalidate
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	//写入和删除会改变缓存内容，没有配置共享密钥、mTLS 或访问控制时拒绝，避免任何客户端都能覆盖缓存值
+	if op != OpRead && !p.authConfigured() {
+		p.audit.Warn("audit: access denied", "remote", r.RemoteAddr, "group", groupname, "op", op,
+			"path", r.URL.Path, "reason", "peer authentication not configured")
+		http.Error(w, "forbidden: peer authentication not configured", http.StatusForbidden)
+		return
+	}
+	if !checkAccess(p.authorizer, p.audit, r, fallback, groupname, op) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 
 	//通过 groupname 得到 group 实例
-	group := GetGroup(groupname)
+	group := p.registry.Get(groupname)
 	if group == nil {
 		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
 		return
 
 	}
+	//按远程节点限速
+	if limiter := p.peerRateLimiter(groupname); limiter != nil {
+		if ok, wait := limiter.Allow(remoteHost(r)); !ok {
+			group.metrics.rateLimited.Add(1)
+			p.logger.Debug("rate limit peer", "group", groupname, "remote", r.RemoteAddr)
+			reject(w, wait)
+			return
+		}
+	}
+
+	if r.Method == http.MethodDelete {
+		invalidate := group.Invalidate
+		if r.Header.Get(forwardedHeader) != "" { //其他节点广播的失效，只删除本节点的缓存值
+			invalidate = group.invalidateLocally
+		}
+		if err := invalidate(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method == http.MethodPut {
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err, http.StatusBadRequest))
+			return
+		}
+		if r.URL.Query().Get("tier") == "hot" { //负责节点推送的热点 key
+			group.hotCache.add(key, ByteView{b: value})
+		} else {
+			group.populateCache(key, ByteView{b: value})
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 
+	if r.Header.Get(forwardedHeader) != "" {
+		ctx = withForwarded(ctx)
+	}
 	//知道缓存名字后获得缓存空间，然后从缓存空间中通过key获得缓存值value
-	value, _ := group.Get(key)
+	value, err := group.GetContext(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrOverloaded) { //让调用方退避重试
+			w.Header().Set("Retry-After", overloadRetryAfter)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	// Write the value to the response body as a proto message.
 	body, err := proto.Marshal(&pb.Response{Value: value.ByteSlice()})
 	if err != nil {
@@ -84,50 +230,195 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// SetAuthorizer 设置访问控制，节点请求需要对缓存空间有相应的操作权限
+func (p *HTTPPool) SetAuthorizer(a Authorizer) {
+	p.authorizer = a
+}
+
+// SetTracer 设置 HTTPPool 使用的追踪钩子，传入 nil 表示不追踪
+func (p *HTTPPool) SetTracer(t Tracer) {
+	if t == nil {
+		t = nopTracer{}
+	}
+	p.tracer = t
+}
+
+// SetBoundedLoad 启用有界负载的一致性哈希：负责节点的在途请求数超过平均值的 (1+epsilon) 倍时，
+// 沿哈希环把 key 交给下一个节点。epsilon <= 0 表示关闭（默认）。只有哈希环支持，其他放置算法忽略该设置
+func (p *HTTPPool) SetBoundedLoad(epsilon float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.loadEpsilon = epsilon
+}
+
+// boundedPlacement 支持有界负载查询的放置算法
+type boundedPlacement interface {
+	GetBounded(key string, epsilon float64, load func(node string) int64) string
+}
+
+// load 返回节点当前的在途请求数：本节点是正在处理的请求，远程节点是发往它且尚未返回的请求
+func (p *HTTPPool) load(node string) int64 {
+	if node == p.self {
+		return p.inflight.Get()
+	}
+	if getter, ok := p.httpGetters[node]; ok {
+		return getter.inflight.Get()
+	}
+	return 0
+}
+
+// bodyErrorStatus body 超出 maxBodyBytes 时返回 413，其他错误返回 status
+func bodyErrorStatus(err error, status int) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return status
+}
+
+// authConfigured 是否配置了共享密钥、mTLS 或访问控制，没有时拒绝所有会修改状态的请求
+func (p *HTTPPool) authConfigured() bool {
+	return len(p.secret) > 0 || p.requireClientCert || p.authorizer != nil
+}
+
+// SetLogger 设置 HTTPPool 使用的日志，传入 nil 表示不输出日志
+func (p *HTTPPool) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	p.logger = l
+}
+
 //客户端功能
 
 type httpGetter struct {
-	baseURL string //baseURL 表示将要访问的远程节点的地址，例如 http://example.com/_geecache/。
+	baseURL string    //baseURL 表示将要访问的远程节点的地址，例如 http://example.com/_geecache/。
+	pool    *HTTPPool //所属的 HTTPPool，复用它的客户端和密钥
+
+	inflight counter    //发往该节点且尚未返回的请求数
+	breaker  *breaker   //可选的熔断器，节点故障时快速失败
+	latency  *histogram //成功请求的耗时，用于计算对冲请求的延迟阈值
 }
 
 // 客户端类要实现PeerGetter接口，就必须实现接口下的方法Get,从Group和key得到缓存值
 // func (h *httpGetter) Get(group string, key string) ([]byte, error) {
-func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf( //格式化
-		"%v%v/%v", //按值的本来值除数
-		h.baseURL,
-		//url.QueryEscape(group), //对参数惊醒转码使之可以安全用在URL查询里
-		//url.QueryEscape(key),
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	) //输出：http://example.com/_geecache/groupname/key
-	//当我们请求服务器时，服务器发送的响应包体被保存在Body中。可以使用它提供的Read方法来获取数据内容。结束的时候，需要调用Body中的Close()方法关闭io。
-	res, err := http.Get(u) //向指定的URL发起Get请求，返回响应
+func (h *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if delay := h.hedgeDelay(); delay > 0 {
+		return h.hedgedGet(ctx, in, out, delay)
+	}
+	return h.withRetry(ctx, func() (bool, error) {
+		return h.get(ctx, in, out)
+	})
+}
 
+// get 发起一次请求，retry 为 true 表示节点故障（网络错误或 502、503、504），可以重试
+func (h *httpGetter) get(ctx context.Context, in *pb.Request, out *pb.Response) (retry bool, err error) {
+	h.inflight.Add(1) //读完响应之前都算在途
+	defer h.inflight.Add(-1)
+	if err := h.breaker.allow(); err != nil {
+		return false, err
+	}
+	start := time.Now()
+	res, err := h.do(ctx, http.MethodGet, in, "", nil)
+	failure := peerFailure(res, err)
+	h.breaker.record(failure)
 	if err != nil {
-		return err
+		return failure != nil, err
 	}
 	defer res.Body.Close()
 
+	if overloaded(res) { //远程节点的加载已达上限，退避重试
+		return true, fmt.Errorf("%w: server returned: %v", ErrOverloaded, res.StatusCode)
+	}
 	if res.StatusCode != http.StatusOK { //响应的状态码不等于http成功状态码200
-		return fmt.Errorf("server returned: %v", res.StatusCode)
+		return failure != nil, fmt.Errorf("server returned: %v", res.StatusCode)
 	}
 
 	bytes, err := io.ReadAll(res.Body) //读取响应body ,上述服务端返回将缓存值作为body
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", res.Body)
+		return true, fmt.Errorf("reading response body: %v", res.Body)
 	}
 	//使用 proto.Unmarshal() 解码 HTTP 响应。
 	if err = proto.Unmarshal(bytes, out); err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+		return false, fmt.Errorf("decoding response body: %v", err)
+	}
+	if h.latency != nil {
+		h.latency.Observe(time.Since(start))
 	}
 	//return bytes, nil
-	return nil
+	return false, nil
+
+}
+
+// Set 把缓存值写入远程节点
+func (h *httpGetter) Set(ctx context.Context, in *pb.Request, value []byte) error {
+	return h.put(ctx, in, "", value)
+}
+
+// SetHot 把缓存值写入远程节点的热点缓存
+func (h *httpGetter) SetHot(ctx context.Context, in *pb.Request, value []byte) error {
+	return h.put(ctx, in, "tier=hot", value)
+}
+
+// Invalidate 删除远程节点上的缓存值，请求带有 forwardedHeader，远程节点不会再广播
+func (h *httpGetter) Invalidate(ctx context.Context, in *pb.Request) error {
+	return h.send(ctx, http.MethodDelete, in, "", nil)
+}
 
+func (h *httpGetter) put(ctx context.Context, in *pb.Request, query string, value []byte) error {
+	return h.send(ctx, http.MethodPut, in, query, value)
+}
+
+// send 发出不需要响应内容的写入或删除请求，远程节点返回 204 表示成功
+func (h *httpGetter) send(ctx context.Context, method string, in *pb.Request, query string, body []byte) error {
+	return h.withRetry(ctx, func() (bool, error) {
+		h.inflight.Add(1)
+		defer h.inflight.Add(-1)
+		if err := h.breaker.allow(); err != nil {
+			return false, err
+		}
+		res, err := h.do(ctx, method, in, query, body)
+		failure := peerFailure(res, err)
+		h.breaker.record(failure)
+		if err != nil {
+			return failure != nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			return failure != nil, fmt.Errorf("server returned: %v", res.StatusCode)
+		}
+		return false, nil
+	})
+}
+
+// do 向远程节点的 /<basepath>/<groupname>/<key>?<query> 发起请求
+func (h *httpGetter) do(ctx context.Context, method string, in *pb.Request, query string, body []byte) (*http.Response, error) {
+	u := fmt.Sprintf( //格式化
+		"%v%v/%v", //按值的本来值除数
+		h.baseURL,
+		//url.QueryEscape(group), //对参数惊醒转码使之可以安全用在URL查询里
+		//url.QueryEscape(key),
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	) //输出：http://example.com/_geecache/groupname/key
+	if query != "" {
+		u += "?" + query
+	}
+	//当我们请求服务器时，服务器发送的响应包体被保存在Body中。可以使用它提供的Read方法来获取数据内容。结束的时候，需要调用Body中的Close()方法关闭io。
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	//把追踪上下文传给远程节点
+	h.pool.tracer.Inject(ctx, req.Header)
+	req.Header.Set(forwardedHeader, "1")
+	signRequest(h.pool.secret, req, body) //配置了共享密钥时对请求签名
+	return h.pool.client.Do(req)          //向指定的URL发起请求，返回响应
 }
 
 // 确保*httpGetter类型实现了PeerGetter接口,编译时检查。如果 *httpGetter 类型没有实现 PeerGetter 接口，这一行代码将在编译时引发错误。
 var _ PeerGetter = (*httpGetter)(nil)
+var _ PeerSetter = (*httpGetter)(nil)
 
 //实现PeerPick接口，这个接口里的方法PickPeer实现：从key选择节点，
 
@@ -135,15 +426,40 @@ var _ PeerGetter = (*httpGetter)(nil)
 const defaultReplicas = 50
 
 func (p *HTTPPool) Set(peers ...string) {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	p.SetWeighted(weights)
+}
+
+// SetWeighted 与 Set 相同，但按权重分配 key，例如 64GB 的节点权重为 8、8GB 的节点权重为 1
+func (p *HTTPPool) SetWeighted(weights map[string]int) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	p.peers = consistenthash.New(defaultReplicas, nil) //复习New参数：每个真实节点有多少个虚拟节点，如果没有自定义哈希函数（nil），就使用默认的
-	p.peers.Add(peers...)                              //添加节点
+	peers := make([]string, 0, len(weights))
+	for peer := range weights {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	old := p.peers
+	p.peers = p.placement()
+	p.peers.AddWeighted(weights) //按权重添加节点
+	p.addrs = peers
+	oldGetters := p.httpGetters
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
 	//为每一个节点创建了一个 HTTP 客户端 httpGetter。
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath} //使用 peer + p.basePath 构建一个 baseURL。
+		p.httpGetters[peer] = &httpGetter{
+			baseURL: peer + p.basePath, //使用 peer + p.basePath 构建一个 baseURL。
+			pool:    p,
+			breaker: p.newPeerBreaker(peer, oldGetters),
+			latency: newHistogram(defaultLatencyBuckets),
+		}
+	}
+	if old != nil {
+		p.startHandoff(old) //节点变更：把归属改变的缓存项交给新的负责节点
 	}
 
 }
@@ -153,9 +469,31 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	if peer := p.peers.Get(key); peer != "" && peer != p.self { //peer是key对应的节点
-		log.Printf("Pick peer %s", peer)
-		return p.httpGetters[peer], true
+	if p.peers == nil {
+		return nil, false
+	}
+	var peer string
+	if bounded, ok := p.peers.(boundedPlacement); ok && p.loadEpsilon > 0 {
+		peer = bounded.GetBounded(key, p.loadEpsilon, p.load)
+	} else {
+		peer = p.peers.Get(key)
+	}
+	if peer != "" && peer != p.self { //peer是key对应的节点
+		if getter := p.httpGetters[peer]; getter.breaker.State() != BreakerOpen {
+			p.logger.Debug("pick peer", "peer", peer, "key_hash", keyHash(key))
+			return getter, true
+		}
+		//熔断器打开：按放置算法的顺序找下一个可用的节点，轮到本节点时在本地加载。
+		//发出的请求带有 forwardedHeader，下一个节点在本地加载，不会再转回熔断的节点
+		for _, node := range p.peers.GetN(key, len(p.addrs)) {
+			if node == p.self {
+				break
+			}
+			if getter := p.httpGetters[node]; node != peer && getter.breaker.State() != BreakerOpen {
+				p.logger.Debug("pick peer", "peer", node, "key_hash", keyHash(key), "skipped", peer)
+				return getter, true
+			}
+		}
 
 	}
 	return nil, false
@@ -0,0 +1,1110 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"geecache/consistenthash"
+	"geecache/lru"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "geecache/geecachepb"
+)
+
+// 测试共享密钥签名：签名正确的请求能拿到值，未签名或密钥不一致的请求被拒绝
+func TestHTTPPoolSecret(t *testing.T) {
+	NewGroup("auth", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := NewHTTPPool("")
+	server.SetSecret([]byte("cluster-secret"))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := NewHTTPPool("")
+	client.SetSecret([]byte("cluster-secret"))
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath, pool: client}
+	res := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "auth", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("signed request failed: %v", err)
+	}
+
+	//未签名的请求
+	resp, err := http.Get(ts.URL + defaultBasePath + "auth/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned request got %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	//密钥不一致
+	client.SetSecret([]byte("wrong-secret"))
+	if err := getter.Get(context.Background(), &pb.Request{Group: "auth", Key: "Tom"}, res); err == nil {
+		t.Fatalf("request signed with wrong secret should be rejected")
+	}
+
+	//写请求不能重放，读请求可以
+	secret := []byte("cluster-secret")
+	serve := func(method string, body []byte, header http.Header) (int, http.Header) {
+		req := httptest.NewRequest(method, defaultBasePath+"auth/Tom", bytes.NewReader(body))
+		if header == nil {
+			signRequest(secret, req, body)
+		} else {
+			req.Header = header.Clone()
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code, req.Header
+	}
+	code, header := serve(http.MethodPut, []byte("630"), nil)
+	if code != http.StatusNoContent {
+		t.Fatalf("signed PUT got %d", code)
+	}
+	if code, _ := serve(http.MethodPut, []byte("630"), header); code != http.StatusUnauthorized {
+		t.Fatalf("replayed PUT got %d, want %d", code, http.StatusUnauthorized)
+	}
+	_, header = serve(http.MethodGet, nil, nil)
+	if code, _ := serve(http.MethodGet, nil, header); code != http.StatusOK {
+		t.Fatalf("repeated GET got %d", code)
+	}
+	//body 超过上限
+	if code, _ := serve(http.MethodPut, make([]byte, maxBodyBytes+1), nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized PUT got %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// writeCert 生成由 parent 签发的证书（parent 为 nil 时自签名），PEM 格式的证书和私钥写入 dir 下的 name.crt、name.key
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// 测试 mTLS：带有 CA 签发的客户端证书的节点可以读写，没有证书或证书不是 CA 签发的请求被拒绝
+func TestMutualTLS(t *testing.T) {
+	NewGroup("mtls", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, true)
+	writeCert(t, dir, "server", ca, caKey, false)
+	writeCert(t, dir, "node", ca, caKey, false)
+	writeCert(t, dir, "rogue", nil, nil, false) //自签名，不是 CA 签发的
+	opts := func(name string) TLSOptions {
+		return TLSOptions{
+			CertFile:          filepath.Join(dir, name+".crt"),
+			KeyFile:           filepath.Join(dir, name+".key"),
+			CAFile:            filepath.Join(dir, "ca.crt"),
+			RequireClientCert: true,
+		}
+	}
+
+	server := NewHTTPPool("")
+	if err := server.SetTLS(opts("server")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := opts("server").ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(server)
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	getter := func(name string) *httpGetter {
+		client := NewHTTPPool("")
+		if err := client.SetTLS(opts(name)); err != nil {
+			t.Fatal(err)
+		}
+		return &httpGetter{baseURL: ts.URL + defaultBasePath, pool: client}
+	}
+	node := getter("node")
+	res := &pb.Response{}
+	if err := node.Get(context.Background(), &pb.Request{Group: "mtls", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("request with a client certificate failed: %q, %v", res.Value, err)
+	}
+	//只配置了 mTLS 时也允许节点写入
+	if err := node.Set(context.Background(), &pb.Request{Group: "mtls", Key: "Jack"}, []byte("589")); err != nil {
+		t.Fatalf("mTLS peer write failed: %v", err)
+	}
+	if v, err := GetGroup("mtls").Get("Jack"); err != nil || v.String() != "589" {
+		t.Fatalf("written value = %q, %v", v.String(), err)
+	}
+
+	if err := getter("rogue").Get(context.Background(), &pb.Request{Group: "mtls", Key: "Tom"}, res); err == nil {
+		t.Fatalf("certificate not signed by the CA should be rejected")
+	}
+	noCert := opts("node")
+	noCert.RequireClientCert = false //不带客户端证书
+	client := NewHTTPPool("")
+	if err := client.SetTLS(noCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&httpGetter{baseURL: ts.URL + defaultBasePath, pool: client}).Get(context.Background(), &pb.Request{Group: "mtls", Key: "Tom"}, res); err == nil {
+		t.Fatalf("request without a client certificate should be rejected")
+	}
+}
+
+// 测试访问控制：令牌只能读取被授权的缓存空间
+func TestHTTPPoolACL(t *testing.T) {
+	for _, name := range []string{"team-a", "team-b"} {
+		NewGroup(name, 2<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				return []byte(key), nil
+			}))
+	}
+	acl, err := LoadACL(strings.NewReader(`{
+		"tokens": {"token-a": "team-a"},
+		"grants": {"team-a": {"team-a": ["read"]}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPPool("")
+	server.SetAuthorizer(acl)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	testCases := []struct {
+		auth, group string
+		status      int
+	}{
+		{"Bearer token-a", "team-a", http.StatusOK},
+		{"Bearer token-a", "team-b", http.StatusForbidden},
+		{"", "team-a", http.StatusForbidden},
+		{"Bearer unknown", "team-a", http.StatusForbidden},
+		{"Basic token-a", "team-a", http.StatusForbidden}, //只接受 Bearer 令牌
+		{"token-a", "team-a", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+defaultBasePath+tc.group+"/Tom", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("authorization %q group %s got %d, want %d", tc.auth, tc.group, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+// 测试集群统计：两个节点的统计按缓存空间汇总
+func TestClusterStats(t *testing.T) {
+	gee := NewGroup("cluster", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+
+	pools := make([]*HTTPPool, 2)
+	addrs := make([]string, 2)
+	for i := range pools {
+		pool := NewHTTPPool("")
+		ts := httptest.NewServer(pool.AdminHandler())
+		defer ts.Close()
+		pool.self = ts.URL
+		pools[i], addrs[i] = pool, ts.URL
+	}
+	for _, pool := range pools {
+		pool.Set(addrs...)
+	}
+
+	//两个节点在同一个进程中，共享同一个 Group，汇总结果应为单节点的两倍
+	cs := pools[0].ClusterStats(context.Background())
+	if len(cs.Nodes) != 2 {
+		t.Fatalf("expect stats from 2 nodes, got %d", len(cs.Nodes))
+	}
+	for _, ns := range cs.Nodes {
+		if ns.Error != "" {
+			t.Fatalf("node %s: %s", ns.Node, ns.Error)
+		}
+	}
+	total := cs.Groups["cluster"]
+	if total.Stats.Gets != 4 || total.Stats.Hits != 2 || total.Cache.Items != 2 || total.HitRatio != 0.5 {
+		t.Fatalf("unexpected cluster stats %+v", total)
+	}
+}
+
+// recordTracer 记录所有 span 的名字及其所属的 trace
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+type traceKey struct{}
+
+type recordSpan struct{}
+
+func (recordSpan) SetAttributes(attrs ...Attribute) {}
+func (recordSpan) RecordError(err error)            {}
+func (recordSpan) End()                             {}
+
+func (t *recordTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	id, ok := ctx.Value(traceKey{}).(string)
+	if !ok {
+		id = "trace-1"
+		ctx = context.WithValue(ctx, traceKey{}, id)
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, id+":"+name)
+	t.mu.Unlock()
+	return ctx, recordSpan{}
+}
+
+func (t *recordTracer) Inject(ctx context.Context, header http.Header) {
+	if id, ok := ctx.Value(traceKey{}).(string); ok {
+		header.Set("X-Trace-Id", id)
+	}
+}
+
+func (t *recordTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	if id := header.Get("X-Trace-Id"); id != "" {
+		ctx = context.WithValue(ctx, traceKey{}, id)
+	}
+	return ctx
+}
+
+// renameGetter 把请求转发到远程节点上另一个名字的 Group，避免同一进程内同一个 Group 转发给自己
+type renameGetter struct {
+	PeerGetter
+	group string
+}
+
+func (r renameGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return r.PeerGetter.Get(ctx, &pb.Request{Group: r.group, Key: in.Key}, out)
+}
+
+type fixedPicker struct{ getter PeerGetter }
+
+func (p fixedPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.getter, true
+}
+
+// 测试追踪：span 覆盖 Get、load、getFromPeer，并且追踪上下文随请求传到远程节点
+func TestTracing(t *testing.T) {
+	tracer := &recordTracer{}
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	gee := NewGroup("trace", 2<<10, getter)
+	gee.SetTracer(tracer)
+	NewGroup("trace-remote", 2<<10, getter).SetTracer(tracer)
+	server := NewHTTPPool("")
+	server.SetTracer(tracer)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	remote := &httpGetter{baseURL: ts.URL + defaultBasePath, pool: server}
+	gee.RegisterPeers(fixedPicker{renameGetter{remote, "trace-remote"}})
+
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"trace-1:geecache.Group.Get",
+		"trace-1:geecache.Group.load",
+		"trace-1:geecache.Group.getFromPeer",
+		"trace-1:geecache.HTTPPool.ServeHTTP", //远程节点延续了同一个 trace
+		"trace-1:geecache.Group.Get",
+		"trace-1:geecache.Group.load",
+		"trace-1:geecache.Group.getLocally",
+	}
+	if !reflect.DeepEqual(tracer.spans, expect) {
+		t.Fatalf("expect spans %v, got %v", expect, tracer.spans)
+	}
+}
+
+// 测试节点变更时的交接：归属改变的缓存项被推送给新的负责节点
+func TestHandoff(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]string)
+	newPeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received[r.URL.Path] = string(body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer newPeer.Close()
+
+	self := "http://self"
+	pool := NewHTTPPool(self)
+	pool.Set(self)
+	gee := NewGroup("handoff", 64<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.RegisterPeers(pool)
+	var keys []string //新节点的端口是随机的，key 足够多才能保证有 key 移动
+	for i := 0; i < 32; i++ {
+		keys = append(keys, "key-"+strconv.Itoa(i))
+	}
+	for _, k := range keys {
+		gee.Get(k) //只有一个节点，全部在本地加载
+	}
+
+	pool.Set(self, newPeer.URL)
+	var expect []string
+	for _, k := range keys {
+		if _, remote := pool.PickPeer(k); remote {
+			expect = append(expect, k)
+		}
+	}
+	if len(expect) == 0 {
+		t.Fatalf("no key moved to the new peer")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n >= len(expect) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range expect {
+		if v, ok := received[defaultBasePath+"handoff/"+k]; !ok || v != k {
+			t.Errorf("key %s not handed off, got %q", k, v)
+		}
+	}
+	if len(received) != len(expect) {
+		t.Errorf("expect %d handed off keys, got %d", len(expect), len(received))
+	}
+}
+
+// hotPicker 本节点负责所有 key，peers 是其他节点
+type hotPicker struct{ peers []PeerGetter }
+
+func (p hotPicker) PickPeer(key string) (PeerGetter, bool) {
+	return nil, false
+}
+
+func (p hotPicker) ListPeers() []PeerGetter {
+	return p.peers
+}
+
+// 测试热点 key：统计访问最多的 key，超过阈值后推送到其他节点的热点缓存
+func TestHotKeys(t *testing.T) {
+	gee := NewGroup("hot", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.SetHotKeys(2, 3)
+	server := NewHTTPPool("")
+	server.SetSecret([]byte("hot-secret")) //写入热点缓存需要节点认证
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	//节点在同一个进程中，推送的热点 key 写入同一个 Group 的热点缓存
+	gee.RegisterPeers(hotPicker{[]PeerGetter{&httpGetter{baseURL: ts.URL + defaultBasePath, pool: server}}})
+
+	for i := 0; i < 3; i++ {
+		gee.Get("Tom")
+	}
+	gee.Get("Jack")
+	gee.Get("Sam") //替换计数最小的 Jack，继承它的计数
+	expect := []HotKey{{Key: "Tom", Count: 3}, {Key: "Sam", Count: 2, Error: 1}}
+	if keys := gee.HotKeys(0); !reflect.DeepEqual(keys, expect) {
+		t.Fatalf("expect hot keys %v, got %v", expect, keys)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if v, ok := gee.hotCache.get("Tom"); ok && v.String() == "Tom" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hot key Tom not promoted to peers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := gee.hotCache.get("Sam"); ok {
+		t.Fatalf("Sam is below the threshold and should not be promoted")
+	}
+
+	admin := httptest.NewServer(NewHTTPPool("").AdminHandler())
+	defer admin.Close()
+	res, err := http.Get(admin.URL + defaultAdminPath + "hotkeys?group=hot&n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var hot map[string][]HotKey
+	if err := json.NewDecoder(res.Body).Decode(&hot); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hot, map[string][]HotKey{"hot": expect[:1]}) {
+		t.Fatalf("admin hotkeys got %v", hot)
+	}
+}
+
+// 测试节点熔断：节点故障后熔断器打开，PickPeer 跳过该节点
+func TestPeerBreaker(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	self := "http://self"
+	pool := NewHTTPPool(self)
+	pool.SetPeerBreaker(&BreakerOptions{MinRequests: 2, OpenTimeout: time.Minute})
+	pool.Set(self, down.URL)
+	var key string
+	for i := 0; ; i++ {
+		if key = "key-" + strconv.Itoa(i); pool.peers.Get(key) == down.URL {
+			break
+		}
+	}
+	peer, ok := pool.PickPeer(key)
+	if !ok {
+		t.Fatalf("key %s should be owned by the remote peer", key)
+	}
+	for i := 0; i < 2; i++ {
+		if err := peer.Get(context.Background(), &pb.Request{Group: "breaker", Key: key}, &pb.Response{}); err == nil {
+			t.Fatalf("request to unavailable peer should fail")
+		}
+	}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "breaker", Key: key}, &pb.Response{}); err != ErrBreakerOpen {
+		t.Fatalf("expect fast fail, got %v", err)
+	}
+	if _, ok := pool.PickPeer(key); ok {
+		t.Fatalf("PickPeer should skip the peer with an open breaker")
+	}
+	if states := pool.PeerBreakers(); states[down.URL] != "open" {
+		t.Fatalf("expect open breaker for %s, got %v", down.URL, states)
+	}
+	pool.Set(self, down.URL) //节点列表变更时保留熔断器状态
+	if _, ok := pool.PickPeer(key); ok {
+		t.Fatalf("breaker state should survive Set")
+	}
+}
+
+// 测试重试：节点返回 503 时退避后重试，直到成功
+func TestRetry(t *testing.T) {
+	NewGroup("retry", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	var mu sync.Mutex
+	attempts := 0
+	server := NewHTTPPool("")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	client := NewHTTPPool("")
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath, pool: client}
+	res := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "retry", Key: "Tom"}, res); err == nil {
+		t.Fatalf("request without retry policy should fail")
+	}
+	client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	if err := getter.Get(context.Background(), &pb.Request{Group: "retry", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("request should succeed after retries: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expect 3 attempts, got %d", attempts)
+	}
+}
+
+// 测试对冲：负责节点的请求超过延迟阈值后，向下一个副本发起请求并使用先返回的结果
+func TestHedging(t *testing.T) {
+	NewGroup("hedge", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("fast-" + key), nil
+		}))
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select { //直到请求被取消
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(NewHTTPPool(""))
+	defer fast.Close()
+
+	self := "http://self"
+	pool := NewHTTPPool(self)
+	pool.SetPlacement(func() consistenthash.Placement { return consistenthash.NewRendezvous(nil) })
+	pool.SetHedging(&HedgePolicy{MinDelay: 20 * time.Millisecond})
+	pool.Set(self, slow.URL, fast.URL)
+	var key string
+	for i := 0; ; i++ {
+		key = "key-" + strconv.Itoa(i)
+		if nodes := pool.peers.GetN(key, 3); nodes[0] == slow.URL && nodes[1] == fast.URL {
+			break
+		}
+	}
+	peer, _ := pool.PickPeer(key)
+	for i := 0; i < hedgeMinSamples; i++ { //历史延迟都是 1ms
+		peer.(*httpGetter).latency.Observe(time.Millisecond)
+	}
+
+	start := time.Now()
+	res := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "hedge", Key: key}, res); err != nil || string(res.Value) != "fast-"+key {
+		t.Fatalf("hedged request = %q, %v", res.Value, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("hedged request took %v", elapsed)
+	}
+}
+
+// 测试限速：同一节点超过突发上限后返回 429 和 Retry-After，其他节点、其他缓存空间不受影响
+func TestPeerRateLimit(t *testing.T) {
+	g := NewGroup("ratelimit", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	NewGroup("ratelimit-free", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	pool := NewHTTPPool("")
+	pool.SetPeerRateLimit("ratelimit", 0.001, 2)
+	get := func(group, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, defaultBasePath+group+"/Tom", nil)
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := get("ratelimit", "10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request within burst should succeed, got %d", w.Code)
+		}
+	}
+	w := get("ratelimit", "10.0.0.1:5678") //端口不同仍是同一节点
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expect 429 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("ratelimit", "10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Fatalf("other peers should not be limited, got %d", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if w := get("ratelimit-free", "10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("group without limit should not be limited, got %d", w.Code)
+		}
+	}
+	if n := g.Stats().RateLimited; n != 1 {
+		t.Fatalf("expect 1 rate limited request, got %d", n)
+	}
+
+	//API 前端按认证后的身份限速，无法识别的令牌按客户端 IP 限速
+	acl := NewACL()
+	acl.AddToken("token-a", "alice")
+	acl.AddToken("token-b", "bob")
+	limiter := NewRateLimiter(0.001, 1)
+	api := RateLimitHandler(limiter, acl, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(token, remote string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api?key=Tom", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w.Code
+	}
+	if call("token-a", "10.0.0.1:1") != http.StatusOK || call("token-a", "10.0.0.2:1") != http.StatusTooManyRequests ||
+		call("token-b", "10.0.0.1:1") != http.StatusOK {
+		t.Fatalf("API clients should be limited by identity")
+	}
+	if call("fake-1", "10.0.0.1:1") != http.StatusOK || call("fake-2", "10.0.0.1:1") != http.StatusTooManyRequests {
+		t.Fatalf("unknown tokens should be limited by client IP")
+	}
+
+	//桶的数量达到上限后拒绝新的 key，已有的 key 不受影响
+	limiter = NewRateLimiter(1000, 1)
+	limiter.maxBuckets = 2
+	for _, key := range []string{"a", "b"} {
+		if ok, _ := limiter.Allow(key); !ok {
+			t.Fatalf("key %s should be allowed", key)
+		}
+	}
+	if ok, _ := limiter.Allow("c"); ok {
+		t.Fatalf("new key should be rejected when the bucket map is full")
+	}
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("expect 2 buckets, got %d", len(limiter.buckets))
+	}
+	time.Sleep(5 * time.Millisecond) //空桶 1ms 就能装满，可以清理
+	if ok, _ := limiter.Allow("c"); !ok {
+		t.Fatalf("refilled buckets should be swept to make room")
+	}
+}
+
+// 测试在线修改容量：管理接口缩小容量时淘汰缓存项，参数错误时返回 400
+func TestSetCacheBytes(t *testing.T) {
+	g := NewGroup("resize", 64<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("value"), nil
+		}))
+	for i := 0; i < 10; i++ {
+		g.Get("key-" + strconv.Itoa(i))
+	}
+	//没有配置认证时拒绝修改容量，只能查看
+	open := httptest.NewServer(NewHTTPPool("").AdminHandler())
+	defer open.Close()
+	req, _ := http.NewRequest(http.MethodPut, open.URL+defaultAdminPath+"cachebytes?group=resize&bytes=1", nil)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("unauthenticated resize should be forbidden, got %v %v", res, err)
+	}
+	if res, err := http.Get(open.URL + defaultAdminPath + "cachebytes?group=resize"); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("unauthenticated GET should be allowed, got %v %v", res, err)
+	}
+
+	admins := NewACL()
+	admins.AddToken("admin-token", "admin")
+	admins.Allow("admin", "*", OpAdmin)
+	pool := NewHTTPPool("")
+	pool.SetAuthorizer(admins)
+	admin := httptest.NewServer(pool.AdminHandler())
+	defer admin.Close()
+	put := func(query string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, admin.URL+defaultAdminPath+"cachebytes?"+query, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	size := 3 * (int64(len("key-0")+len("value")) + lru.EntryOverhead) //容纳 3 个缓存项
+	res := put("group=resize&bytes=" + strconv.FormatInt(size, 10))
+	defer res.Body.Close()
+	var stats CacheStats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.MaxBytes != size || stats.Items != 3 {
+		t.Fatalf("cache should shrink to 3 entries, got %+v", stats)
+	}
+	if res := put("group=resize&bytes=-1"); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expect 400 for bad bytes, got %d", res.StatusCode)
+	}
+	if res := put("group=missing&bytes=30"); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expect 404 for unknown group, got %d", res.StatusCode)
+	}
+
+	g.SetCacheBytes(64 << 10) //扩大后保留原有缓存项
+	if s := g.CacheStats(); s.Items != stats.Items || s.MaxBytes != 64<<10 {
+		t.Fatalf("growing should keep entries, got %+v", s)
+	}
+
+	//只有 resize 缓存空间 admin 权限的令牌只能修改该缓存空间
+	acl := NewACL()
+	acl.AddToken("ops-token", "ops")
+	acl.Allow("ops", "resize", OpAdmin)
+	pool = NewHTTPPool("")
+	pool.SetAuthorizer(acl)
+	scoped := httptest.NewServer(pool.AdminHandler())
+	defer scoped.Close()
+	call := func(method, path string) int {
+		req, _ := http.NewRequest(method, scoped.URL+defaultAdminPath+path, nil)
+		req.Header.Set("Authorization", "Bearer ops-token")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := call(http.MethodPut, "cachebytes?group=resize&bytes=65536"); code != http.StatusOK {
+		t.Fatalf("group admin should resize its group, got %d", code)
+	}
+	if code := call(http.MethodPut, "cachebytes?group=other&bytes=65536"); code != http.StatusForbidden {
+		t.Fatalf("group admin should not resize other groups, got %d", code)
+	}
+	if code := call(http.MethodGet, "stats"); code != http.StatusForbidden {
+		t.Fatalf("group admin should not read all stats, got %d", code)
+	}
+}
+
+// 测试删除缓存值：需要 invalidate 权限，删除后重新调用 Getter 加载
+func TestInvalidate(t *testing.T) {
+	var loads int64
+	g := NewGroup("invalidate", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt64(&loads, 1)
+			return []byte(key), nil
+		}))
+	acl, err := LoadACL(strings.NewReader(`{
+		"tokens": {"reader": "reader", "ops": "ops"},
+		"grants": {"reader": {"invalidate": ["read"]}, "ops": {"invalidate": ["read", "invalidate"]}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPPool("")
+	server.SetAuthorizer(acl)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	g.Get("Tom")
+	del := func(token string) int {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+defaultBasePath+"invalidate/Tom", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := del("reader"); code != http.StatusForbidden {
+		t.Fatalf("delete without invalidate grant got %d", code)
+	}
+	if code := del("ops"); code != http.StatusNoContent {
+		t.Fatalf("delete with invalidate grant got %d", code)
+	}
+	g.Get("Tom")
+	if n := atomic.LoadInt64(&loads); n != 2 {
+		t.Fatalf("expect Tom to be reloaded after invalidation, got %d loads", n)
+	}
+}
+
+// 测试失效广播：一个节点上的 Invalidate 删除所有节点上的副本和热点缓存
+func TestInvalidateBroadcast(t *testing.T) {
+	nodes := startCluster(t, "broadcast", 3, 0, func(p *HTTPPool) {
+		p.SetSecret([]byte("cluster-secret"))
+	})
+	for _, node := range nodes {
+		node.group.mainCache.add("Tom", ByteView{b: []byte("stale")})
+		node.group.hotCache.add("Tom", ByteView{b: []byte("stale")})
+	}
+	if err := nodes[0].group.Invalidate("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	for i, node := range nodes {
+		if _, ok := node.group.mainCache.get("Tom"); ok {
+			t.Fatalf("node %d still has Tom in main cache", i)
+		}
+		if _, ok := node.group.hotCache.get("Tom"); ok {
+			t.Fatalf("node %d still has Tom in hot cache", i)
+		}
+	}
+}
+
+// 测试审计日志：没有设置 Logger 时被拒绝的请求仍然输出到标准库的 log
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	server := NewHTTPPool("")
+	server.SetAuthorizer(NewACL())
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultBasePath+"audit/Tom", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expect 403, got %d", rec.Code)
+	}
+	if !strings.Contains(buf.String(), "audit: access denied") {
+		t.Fatalf("expect audit record by default, got %q", buf.String())
+	}
+
+	var custom bytes.Buffer
+	server.SetAuditLogger(NewStdLogger(log.New(&custom, "", 0), LevelInfo))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, defaultBasePath+"audit/Tom", nil))
+	if !strings.Contains(custom.String(), "group=audit") {
+		t.Fatalf("expect audit record in custom sink, got %q", custom.String())
+	}
+}
+
+// 测试节点写入：没有配置节点认证时拒绝 PUT，配置共享密钥后签名的写入生效
+func TestPeerWriteAuth(t *testing.T) {
+	g := NewGroup("peer-write", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	open := NewHTTPPool("")
+	for _, query := range []string{"", "?tier=hot"} {
+		rec := httptest.NewRecorder()
+		open.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, defaultBasePath+"peer-write/Tom"+query, strings.NewReader("forged")))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("unauthenticated PUT%s got %d, want 403", query, rec.Code)
+		}
+	}
+	if v, err := g.Get("Tom"); err != nil || v.String() != "Tom" {
+		t.Fatalf("forged value should not be cached, got %q", v.String())
+	}
+
+	server := NewHTTPPool("")
+	server.SetSecret([]byte("write-secret"))
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := NewHTTPPool("")
+	client.SetSecret([]byte("write-secret"))
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath, pool: client}
+	if err := getter.Set(context.Background(), &pb.Request{Group: "peer-write", Key: "Jack"}, []byte("pushed")); err != nil {
+		t.Fatalf("signed PUT failed: %v", err)
+	}
+	if v, _ := g.Get("Jack"); v.String() != "pushed" {
+		t.Fatalf("signed PUT should populate the cache, got %q", v.String())
+	}
+}
+
+// testNode 同一进程中的一个节点，拥有独立的注册表和 Group，loads 记录从数据源加载的次数
+type testNode struct {
+	pool  *HTTPPool
+	group *Group
+	loads int32
+}
+
+// startCluster 启动 n 个节点，每个节点在自己的注册表中创建同名的 Group，数据源耗时 delay，
+// configure 在设置节点列表之前调整 HTTPPool
+func startCluster(t *testing.T, name string, n int, delay time.Duration, configure func(p *HTTPPool)) []*testNode {
+	nodes := make([]*testNode, n)
+	addrs := make([]string, n)
+	for i := range nodes {
+		node := &testNode{pool: NewHTTPPool("")}
+		ts := httptest.NewServer(node.pool)
+		t.Cleanup(ts.Close)
+		node.pool.self = ts.URL
+		registry := NewRegistry()
+		node.pool.SetRegistry(registry)
+		id := strconv.Itoa(i)
+		group, err := registry.NewGroup(name, 2<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				atomic.AddInt32(&node.loads, 1)
+				time.Sleep(delay)
+				return []byte("node-" + id + ":" + key), nil
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		group.RegisterPeers(node.pool)
+		if configure != nil {
+			configure(node.pool)
+		}
+		node.group = group
+		nodes[i], addrs[i] = node, ts.URL
+	}
+	for _, node := range nodes {
+		node.pool.Set(addrs...)
+	}
+	return nodes
+}
+
+// 测试有界负载：负责节点过载时溢出的请求由接收节点在本地加载，不会被转回负责节点
+func TestBoundedLoadForwarding(t *testing.T) {
+	nodes := startCluster(t, "bounded", 3, 100*time.Millisecond, func(p *HTTPPool) {
+		p.SetBoundedLoad(0.25)
+	})
+	entry := nodes[0]
+	owner := 1
+	var keys []string
+	for i := 0; len(keys) < 30; i++ {
+		if key := "key-" + strconv.Itoa(i); entry.pool.peers.Get(key) == nodes[owner].pool.self {
+			keys = append(keys, key)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if _, err := entry.group.Get(key); err != nil {
+				t.Error(err)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	var total int32
+	for i, node := range nodes {
+		total += atomic.LoadInt32(&node.loads)
+		if i != 0 && node.group.metrics.peerLoads.Get()+node.group.metrics.peerErrs.Get() != 0 {
+			t.Fatalf("node %d forwarded a peer request", i)
+		}
+	}
+	if total != int32(len(keys)) {
+		t.Fatalf("expect %d loads, got %d", len(keys), total)
+	}
+	if loads := atomic.LoadInt32(&nodes[owner].loads); loads >= int32(len(keys)) || loads == 0 {
+		t.Fatalf("owner loaded %d of %d keys", loads, len(keys))
+	}
+}
+
+// 测试熔断：负责节点的熔断器打开后请求交给下一个节点，由它在本地加载，不会再转回负责节点
+func TestBreakerForwarding(t *testing.T) {
+	nodes := startCluster(t, "breaker-forward", 3, 0, func(p *HTTPPool) {
+		p.SetPeerBreaker(&BreakerOptions{MinRequests: 1, OpenTimeout: time.Minute})
+	})
+	entry, owner, next := nodes[0], nodes[1], nodes[2]
+	var key string
+	for i := 0; ; i++ {
+		key = "key-" + strconv.Itoa(i)
+		if order := entry.pool.peers.GetN(key, 3); order[0] == owner.pool.self && order[1] == next.pool.self {
+			break
+		}
+	}
+	entry.pool.httpGetters[owner.pool.self].breaker.record(errors.New("down"))
+
+	v, err := entry.group.Get(key)
+	if err != nil || v.String() != "node-2:"+key {
+		t.Fatalf("Get(%s) = %q, %v; expect it loaded by the next node", key, v.String(), err)
+	}
+	if loads := atomic.LoadInt32(&owner.loads); loads != 0 {
+		t.Fatalf("owner with open breaker loaded %d keys", loads)
+	}
+	if n := next.group.metrics.peerLoads.Get() + next.group.metrics.peerErrs.Get(); n != 0 {
+		t.Fatalf("next node forwarded %d requests", n)
+	}
+}
+
+// 测试对冲：只对冲到持有副本的节点，没有其他副本时在本地加载；接收对冲请求的节点在本地加载，不会再转给慢节点
+func TestHedgeForwarding(t *testing.T) {
+	for _, replicas := range []int{1, 2} {
+		name := "hedge-forward-" + strconv.Itoa(replicas)
+		nodes := startCluster(t, name, 3, 0, func(p *HTTPPool) {
+			p.SetHedging(&HedgePolicy{MinDelay: 20 * time.Millisecond})
+		})
+		entry, owner, other := nodes[0], nodes[1], nodes[2]
+		for _, node := range nodes {
+			node.group.SetReplicas(replicas)
+		}
+		owner.group.getter = GetterFunc(func(key string) ([]byte, error) {
+			time.Sleep(time.Second)
+			return []byte("slow"), nil
+		})
+		var key string
+		for i := 0; ; i++ {
+			key = "key-" + strconv.Itoa(i)
+			if order := entry.pool.peers.GetN(key, 3); order[0] == owner.pool.self && order[1] == other.pool.self {
+				break
+			}
+		}
+		for i := 0; i < hedgeMinSamples; i++ { //历史延迟都是 1ms
+			entry.pool.httpGetters[owner.pool.self].latency.Observe(time.Millisecond)
+		}
+
+		start := time.Now()
+		v, err := entry.group.Get(key)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("replicas=%d: hedged Get took %v", replicas, elapsed)
+		}
+		//只有一个副本时在本地加载，两个副本时对冲到第二个副本节点
+		want, hedged := "node-0:"+key, entry
+		if replicas > 1 {
+			want, hedged = "node-2:"+key, other
+		}
+		if err != nil || v.String() != want {
+			t.Fatalf("replicas=%d: Get(%s) = %q, %v, want %q", replicas, key, v.String(), err, want)
+		}
+		for i, node := range nodes {
+			if node != hedged && atomic.LoadInt32(&node.loads) != 0 {
+				t.Fatalf("replicas=%d: node %d should not load", replicas, i)
+			}
+		}
+		if n := other.group.metrics.peerLoads.Get() + other.group.metrics.peerErrs.Get(); n != 0 {
+			t.Fatalf("replicas=%d: hedge receiver forwarded %d requests", replicas, n)
+		}
+	}
+}
+
+// 测试负责节点过载：调用方收到 ErrOverloaded，不会转而在本地调用 Getter，把过载扩散到自己的数据源
+func TestPeerOverloaded(t *testing.T) {
+	nodes := startCluster(t, "peer-overloaded", 2, 0, func(p *HTTPPool) {
+		p.SetPeerBreaker(&BreakerOptions{MinRequests: 1, OpenTimeout: time.Minute})
+	})
+	entry, owner := nodes[0], nodes[1]
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	owner.group.getter = GetterFunc(func(key string) ([]byte, error) {
+		started <- struct{}{}
+		<-release
+		return []byte(key), nil
+	})
+	owner.group.SetLoadLimit(1, 0)
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		if key := "key-" + strconv.Itoa(i); entry.pool.peers.Get(key) == owner.pool.self {
+			keys = append(keys, key)
+		}
+	}
+	go owner.group.Get(keys[0]) //占用负责节点唯一的空位
+	<-started
+
+	if _, err := entry.group.Get(keys[1]); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expect ErrOverloaded from the owner, got %v", err)
+	}
+	if loads := atomic.LoadInt32(&entry.loads); loads != 0 {
+		t.Fatalf("caller should not fall back to its own getter, loaded %d keys", loads)
+	}
+	if state := entry.pool.PeerBreakers()[owner.pool.self]; state != "closed" {
+		t.Fatalf("an overloaded peer should not trip the breaker, got %s", state)
+	}
+}
@@ -0,0 +1,86 @@
+package geecache
+
+/*
+加载限流：限制一个 Group 同时调用 Getter 的数量（被 singleflight 合并后的），保护本节点的数据源，
+读磁盘和请求远程节点不占用名额。超过上限的加载在有界队列中等待空位，队列也满了就立即返回 ErrOverloaded。
+共享的加载不随调用者取消，排队也有时限，等待超过 loadQueueTimeout 同样返回 ErrOverloaded，
+不会在所有调用者都已离开之后才调用 Getter。
+HTTPPool 对其他节点返回 503 和 Retry-After，调用方退避重试（配置了重试策略时）后把 ErrOverloaded 返回给自己的调用者，
+不会转而在本地加载，否则负责节点的过载会扩散到每个节点的数据源
+*/
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded 同时进行的加载和等待的加载都已达到上限，或者排队等待超时
+var ErrOverloaded = errors.New("geecache: too many concurrent loads")
+
+const (
+	overloadRetryAfter = "1"             //过载时建议客户端等待的秒数，写入 Retry-After 响应头
+	loadQueueTimeout   = 5 * time.Second //加载排队等待空位的最长时间
+)
+
+// overloaded 判断远程节点的响应是否表示其加载已达上限
+func overloaded(res *http.Response) bool {
+	return res.StatusCode == http.StatusServiceUnavailable && res.Header.Get("Retry-After") != ""
+}
+
+// loadLimiter 并发加载限制，nil 表示不限制
+type loadLimiter struct {
+	slots    chan struct{} //容量为最大并发数
+	maxQueue int64         //最多等待的加载数
+	waiting  int64         //正在等待的加载数
+	maxWait  time.Duration //排队等待的最长时间
+}
+
+func newLoadLimiter(maxConcurrent, maxQueue int) *loadLimiter {
+	return &loadLimiter{slots: make(chan struct{}, maxConcurrent), maxQueue: int64(maxQueue), maxWait: loadQueueTimeout}
+}
+
+// acquire 获取一个空位，没有空位时排队等待；队列已满或等待超过 maxWait 返回 ErrOverloaded，ctx 结束返回 ctx.Err()
+func (l *loadLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&l.waiting, 1) > l.maxQueue {
+		atomic.AddInt64(&l.waiting, -1)
+		return ErrOverloaded
+	}
+	defer atomic.AddInt64(&l.waiting, -1)
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrOverloaded
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *loadLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// SetLoadLimit 限制同时进行的加载数量为 maxConcurrent，最多 maxQueue 个加载排队等待，
+// 再多的加载立即返回 ErrOverloaded。maxConcurrent <= 0 表示不限制（默认）
+func (g *Group) SetLoadLimit(maxConcurrent, maxQueue int) {
+	if maxConcurrent <= 0 {
+		g.limiter = nil
+		return
+	}
+	g.limiter = newLoadLimiter(maxConcurrent, maxQueue)
+}
@@ -1,12 +1,23 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"unsafe"
+)
+
+// EntryOverhead 估算每个节点除 key 和 value 内容之外占用的内存：
+// map 中的 key 和指针（按装载因子放大 2 倍）、list.Element、entry，以及 value 装箱后的切片头
+const EntryOverhead = int64(2*(unsafe.Sizeof("")+unsafe.Sizeof(&list.Element{})) +
+	unsafe.Sizeof(list.Element{}) + unsafe.Sizeof(entry{}) + unsafe.Sizeof([]byte(nil)))
 
 type Cache struct {
 	cache    map[string]*list.Element //列表里的指针
 	l1       *list.List               //列表
 	nbytes   int64                    //内存
 	maxBytes int64                    //缓存最大值
+
+	OnEvicted func(key string, value Value) //可选，节点因容量不足被淘汰时调用
+	Overhead  int64                         //可选，每个节点额外计入的内存，通常为 EntryOverhead
 }
 
 func New(maxBytes int64) *Cache { //相当于初始化
@@ -26,6 +37,19 @@ func (c *Cache) Len() int {
 	return c.l1.Len() //返回链表的长度
 }
 
+// 当前占用的内存（key 和 value 的长度之和，加上每个节点的 Overhead）
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// Resize 修改缓存最大值：缩小时淘汰最久未访问的节点直到不超过新的最大值，扩大时保留所有节点
+func (c *Cache) Resize(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.nbytes > c.maxBytes {
+		c.RemoveOldest()
+	}
+}
+
 type entry struct {
 	key   string
 	value Value
@@ -43,31 +67,57 @@ func (c *Cache) Get(key string) (val Value, ok bool) {
 
 }
 
+// Range 从最久未访问到最近访问依次对每个节点调用 f，f 返回 false 时停止，不改变访问顺序
+func (c *Cache) Range(f func(key string, value Value) bool) {
+	for ele := c.l1.Back(); ele != nil; ele = ele.Prev() {
+		kv := ele.Value.(*entry)
+		if !f(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
 //淘汰 移除最少访问的节点（队首）
 func (c *Cache) RemoveOldest() {
 	ele := c.l1.Back() //取队首节点
 	if ele != nil {
 		c.l1.Remove(ele) //从链表中删掉节点
 		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)                                //从map中删除映射关系
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) //把key和value的长度从内存中减掉
+		delete(c.cache, kv.key)                                             //从map中删除映射关系
+		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) + c.Overhead //把key和value的长度从内存中减掉
+		if c.OnEvicted != nil {
+			c.OnEvicted(kv.key, kv.value)
+		}
 
 	}
 
 }
 
+// Remove 删除 key 对应的节点，不调用 OnEvicted，key 不存在时返回 false
+func (c *Cache) Remove(key string) bool {
+	ele, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	c.l1.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) + c.Overhead
+	return true
+}
+
 //新增
 func (c *Cache) Add(key string, value Value) {
 	//如果键存在，更新对应节点的值
 	if ele, ok := c.cache[key]; ok {
 		kv := ele.Value.(*entry)
-		kv.value = value
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len()) //更新内存，加上现在的vlaue长度，减去原来的value长度
-		c.l1.MoveToFront(ele)                                  //修改相当于访问了，把节点移动到队尾
+		kv.value = value
+		c.l1.MoveToFront(ele) //修改相当于访问了，把节点移动到队尾
 	} else { //如果不存在
-		ele := c.l1.PushFront(&entry{key, value})        //在队尾加入新的节点
-		c.cache[key] = ele                               //在map中添加映射
-		c.nbytes += int64(len(key)) + int64(value.Len()) //加内存
+		ele := c.l1.PushFront(&entry{key, value})                     //在队尾加入新的节点
+		c.cache[key] = ele                                            //在map中添加映射
+		c.nbytes += int64(len(key)) + int64(value.Len()) + c.Overhead //加内存
 	}
 	//保持内存不超过最大值,超过时，执行淘汰
 	for c.maxBytes != 0 && c.nbytes > c.maxBytes {
@@ -1,6 +1,7 @@
 package lru
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -41,3 +42,69 @@ func TestRemoveoldest(t *testing.T) {
 		t.Fatalf("Removeoldest key1 failed")
 	}
 }
+
+// 测试淘汰回调
+func TestOnEvicted(t *testing.T) {
+	keys := make([]string, 0)
+	lru := New(int64(10))
+	lru.OnEvicted = func(key string, value Value) {
+		keys = append(keys, key)
+	}
+	lru.Add("key1", String("123456"))
+	lru.Add("k2", String("k2"))
+	lru.Add("k3", String("k3"))
+	lru.Add("k4", String("k4"))
+
+	expect := []string{"key1", "k2"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+	if lru.Bytes() != 8 {
+		t.Fatalf("expect 8 bytes in cache, got %d", lru.Bytes())
+	}
+}
+
+// 测试修改容量：缩小时淘汰最久未访问的节点，扩大时保留所有节点
+func TestResize(t *testing.T) {
+	lru := New(int64(12))
+	lru.Add("k1", String("1234"))
+	lru.Add("k2", String("1234"))
+	lru.Get("k1") //k2 成为最久未访问的节点
+	lru.Resize(6)
+	if _, ok := lru.Get("k2"); ok || lru.Len() != 1 || lru.Bytes() != 6 {
+		t.Fatalf("Resize should evict k2, got %d entries", lru.Len())
+	}
+	lru.Resize(100)
+	lru.Add("k3", String("1234"))
+	if _, ok := lru.Get("k1"); !ok || lru.Len() != 2 {
+		t.Fatalf("Resize should keep entries when growing")
+	}
+}
+
+// 测试每个节点的额外开销计入内存
+func TestOverhead(t *testing.T) {
+	lru := New(int64(2 * (4 + EntryOverhead)))
+	lru.Overhead = EntryOverhead
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	if lru.Bytes() != 2*(4+EntryOverhead) || lru.Len() != 2 {
+		t.Fatalf("expect overhead to be counted, got %d bytes", lru.Bytes())
+	}
+	lru.Add("k3", String("v3"))
+	if lru.Len() != 2 || lru.Bytes() != 2*(4+EntryOverhead) {
+		t.Fatalf("expect k1 to be evicted, got %d entries", lru.Len())
+	}
+}
+
+// 测试删除节点
+func TestRemove(t *testing.T) {
+	lru := New(int64(0))
+	lru.Add("k1", String("1234"))
+	lru.Add("k2", String("1234"))
+	if !lru.Remove("k1") || lru.Remove("k1") {
+		t.Fatalf("Remove should delete k1 once")
+	}
+	if _, ok := lru.Get("k1"); ok || lru.Len() != 1 || lru.Bytes() != 6 {
+		t.Fatalf("expect only k2 left, got %d entries and %d bytes", lru.Len(), lru.Bytes())
+	}
+}
@@ -0,0 +1,213 @@
+package geecache
+
+/*
+进程级内存管理：每个 Group 在 NewGroup 时有自己固定的 cacheBytes，Group 多了要么超出内存，要么浪费。
+注册到 MemoryManager 的 Group 共享一个总的字节预算：
+1、注册时按优先级权重分配预算，权重越大分到的越多
+2、Rebalance 按边际命中率在 Group 之间移动容量：每个 Group 记录最近淘汰的 step 字节缓存项（ghost），
+  未命中但在 ghost 中的次数就是容量多 step 字节时能多命中的次数，容量从收益最低的 Group 移到收益最高的 Group
+3、每个 Group 的预算不低于按权重分配的一半，保护重要的 Group 不被挤掉
+预算同时包含主缓存和热点缓存
+*/
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	rebalanceStepRatio = 20 //每次 Rebalance 移动总预算的 1/20
+	minShareRatio      = 2  //每个 Group 的预算不低于按权重分配的 1/minShareRatio
+)
+
+// MemoryManager 让多个 Group 共享一个总的字节预算，并发安全
+type MemoryManager struct {
+	lock   sync.Mutex
+	total  int64                    //总预算
+	groups map[*Group]*managedGroup //注册的 Group，不同注册表中的 Group 可以同名
+	seq    int64                    //注册顺序，同名 Group 按注册顺序排序
+}
+
+// managedGroup 一个注册的 Group 及其预算
+type managedGroup struct {
+	group    *Group
+	weight   int
+	budget   int64 //当前预算，包含主缓存和热点缓存
+	lastHits int64 //上次 Rebalance 时的 marginalHits
+	seq      int64 //注册顺序
+}
+
+// NewMemoryManager 创建总预算为 totalBytes 字节的内存管理器
+func NewMemoryManager(totalBytes int64) *MemoryManager {
+	return &MemoryManager{total: totalBytes, groups: make(map[*Group]*managedGroup)}
+}
+
+// Register 把 g 交给内存管理器管理，weight 为优先级权重（小于 1 时按 1 处理），重复注册时更新权重。
+// 注册或注销都会按权重重新分配所有 Group 的预算，之后 g 原来的 cacheBytes 不再生效
+func (m *MemoryManager) Register(g *Group, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.lock.Lock()
+	if mg, ok := m.groups[g]; ok {
+		mg.weight = weight
+	} else {
+		m.seq++
+		m.groups[g] = &managedGroup{group: g, weight: weight, seq: m.seq}
+	}
+	g.mainCache.setGhost(m.step())
+	m.redistribute()
+	m.lock.Unlock()
+	g.onClose(func() { m.Unregister(g) }) //关闭的 Group 交还预算，已关闭时立即注销，不能持有锁
+}
+
+// Unregister 不再管理 g，g 保留当前的容量，其余 Group 重新分配总预算。Group 关闭时自动注销
+func (m *MemoryManager) Unregister(g *Group) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.groups[g]; !ok {
+		return
+	}
+	delete(m.groups, g)
+	g.mainCache.setGhost(0)
+	m.redistribute()
+}
+
+// SetTotalBytes 修改总预算，各 Group 的预算按比例缩放，用于内存压力下整体收缩
+func (m *MemoryManager) SetTotalBytes(totalBytes int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	old := m.total
+	m.total = totalBytes
+	gs := m.sorted()
+	if len(gs) == 0 || old <= 0 {
+		m.redistribute()
+		return
+	}
+	ratio := float64(totalBytes) / float64(old)
+	rest := totalBytes
+	for i, mg := range gs {
+		if i == len(gs)-1 {
+			mg.budget = rest //舍入的误差给最后一个，保证总和不变
+		} else {
+			mg.budget = int64(float64(mg.budget) * ratio)
+			rest -= mg.budget
+		}
+	}
+	for _, mg := range gs { //所有 Group 同时缩小或同时扩大，不会超过总预算
+		mg.group.mainCache.setGhost(m.step())
+		m.apply(mg)
+	}
+}
+
+// Budgets 返回每个 Group 当前的预算
+func (m *MemoryManager) Budgets() map[*Group]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	budgets := make(map[*Group]int64, len(m.groups))
+	for g, mg := range m.groups {
+		budgets[g] = mg.budget
+	}
+	return budgets
+}
+
+// Rebalance 把 step 字节的预算从边际命中率最低的 Group 移到最高的 Group，
+// 边际命中率按优先级权重放大，没有 Group 能从更多容量中受益时不做调整
+func (m *MemoryManager) Rebalance() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	step := m.step()
+	var to, from *managedGroup
+	var toGain, fromGain int64
+	for _, mg := range m.sorted() {
+		hits := mg.group.mainCache.marginalHits()
+		gain := (hits - mg.lastHits) * int64(mg.weight)
+		mg.lastHits = hits
+		if gain > 0 && (to == nil || gain > toGain) {
+			to, toGain = mg, gain
+		}
+		if mg.budget-step >= m.floor(mg) && (from == nil || gain < fromGain) {
+			from, fromGain = mg, gain
+		}
+	}
+	if to == nil || from == nil || to == from || toGain <= fromGain {
+		return
+	}
+	from.budget -= step
+	to.budget += step
+	m.apply(from) //先缩小再扩大，任何时刻都不超过总预算
+	m.apply(to)
+	to.group.logger.Debug("memory rebalanced", "from", from.group.name, "to", to.group.name, "bytes", step)
+}
+
+// Run 每隔 interval 调用一次 Rebalance，直到 ctx 被取消
+func (m *MemoryManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Rebalance()
+		}
+	}
+}
+
+// redistribute 按权重重新分配总预算，在持有锁时调用
+func (m *MemoryManager) redistribute() {
+	gs := m.sorted()
+	var weights int64
+	for _, mg := range gs {
+		weights += int64(mg.weight)
+	}
+	//先缩小再扩大，任何时刻都不超过总预算
+	sort.SliceStable(gs, func(i, j int) bool {
+		return m.total*int64(gs[i].weight)/weights-gs[i].budget < m.total*int64(gs[j].weight)/weights-gs[j].budget
+	})
+	for _, mg := range gs {
+		mg.budget = m.total * int64(mg.weight) / weights
+		mg.lastHits = mg.group.mainCache.marginalHits()
+		m.apply(mg)
+	}
+}
+
+// apply 按预算设置 Group 的容量，主缓存与热点缓存按 hotCacheRatio:1 分配
+func (m *MemoryManager) apply(mg *managedGroup) {
+	cacheBytes := mg.budget * hotCacheRatio / (hotCacheRatio + 1)
+	if cacheBytes < 1 {
+		cacheBytes = 1 //0 表示不限制容量
+	}
+	mg.group.SetCacheBytes(cacheBytes)
+}
+
+// step 每次 Rebalance 移动的字节数，也是 ghost 的容量
+func (m *MemoryManager) step() int64 {
+	return m.total / rebalanceStepRatio
+}
+
+// floor 返回 mg 的预算下限，在持有锁时调用
+func (m *MemoryManager) floor(mg *managedGroup) int64 {
+	var weights int64
+	for _, o := range m.groups {
+		weights += int64(o.weight)
+	}
+	return m.total * int64(mg.weight) / weights / minShareRatio
+}
+
+// sorted 按名字和注册顺序排序返回注册的 Group，保证 Rebalance 的结果稳定
+func (m *MemoryManager) sorted() []*managedGroup {
+	gs := make([]*managedGroup, 0, len(m.groups))
+	for _, mg := range m.groups {
+		gs = append(gs, mg)
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		if gs[i].group.name != gs[j].group.name {
+			return gs[i].group.name < gs[j].group.name
+		}
+		return gs[i].seq < gs[j].seq
+	})
+	return gs
+}
@@ -0,0 +1,177 @@
+package geecache
+
+/*
+指标统计：每个 Group 维护一组计数器和延迟直方图，
+MetricsHandler 以 Prometheus 文本格式输出所有 Group 的指标，不依赖第三方库
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// counter 并发安全的计数器
+type counter int64
+
+func (c *counter) Add(n int64) {
+	atomic.AddInt64((*int64)(c), n)
+}
+
+func (c *counter) Get() int64 {
+	return atomic.LoadInt64((*int64)(c))
+}
+
+// 延迟直方图的桶上界（秒）
+var defaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram 固定桶的延迟直方图，counts[i] 记录落在 (buckets[i-1], buckets[i]] 的次数，最后一个为 +Inf
+type histogram struct {
+	buckets []float64
+	counts  []int64
+	sum     int64 //总耗时（纳秒）
+	count   int64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)+1),
+	}
+}
+
+func (h *histogram) Observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.buckets, d.Seconds()) //第一个 >= d 的桶
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddInt64(&h.count, 1)
+}
+
+// Count 返回观测次数
+func (h *histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Quantile 估计 q 分位的耗时，返回该分位所在桶的上界，落在 +Inf 桶时返回最大的桶上界
+func (h *histogram) Quantile(q float64) time.Duration {
+	target := int64(math.Ceil(q * float64(h.Count())))
+	var cumulative int64
+	for i, le := range h.buckets {
+		if cumulative += atomic.LoadInt64(&h.counts[i]); cumulative >= target {
+			return time.Duration(le * float64(time.Second))
+		}
+	}
+	return time.Duration(h.buckets[len(h.buckets)-1] * float64(time.Second))
+}
+
+// groupMetrics 一个 Group 的全部指标
+type groupMetrics struct {
+	gets        counter //Get 调用次数
+	hits        counter //命中本地缓存
+	misses      counter //未命中，需要加载
+	hotHits     counter //命中热点缓存
+	diskHits    counter //命中磁盘二级缓存
+	loads       counter //进入 load 的次数（包含被 singleflight 合并的）
+	dedups      counter //被 singleflight 合并、没有真正执行加载的次数
+	overloaded  counter //并发加载过多而被拒绝的次数
+	rateLimited counter //超过限速而被拒绝的请求数
+	localLoads  counter //调用 Getter 成功加载
+	localErrs   counter //调用 Getter 失败
+	peerLoads   counter //从远程节点成功加载
+	peerErrs    counter //从远程节点加载失败
+
+	getterLatency *histogram //Getter 调用耗时
+	peerLatency   *histogram //远程节点调用耗时
+}
+
+func newGroupMetrics() *groupMetrics {
+	return &groupMetrics{
+		getterLatency: newHistogram(defaultLatencyBuckets),
+		peerLatency:   newHistogram(defaultLatencyBuckets),
+	}
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出默认注册表中所有 Group 指标的 http.Handler，通常挂载在 /metrics
+func MetricsHandler() http.Handler {
+	return defaultRegistry.MetricsHandler()
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出注册表中所有 Group 指标的 http.Handler
+func (r *Registry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		writeMetrics(bw, r.Groups())
+		bw.Flush()
+	})
+}
+
+func writeMetrics(w *bufio.Writer, gs []*Group) {
+	stats := make([]Stats, len(gs))
+	cacheStats := make([]CacheStats, len(gs))
+	for i, g := range gs {
+		stats[i] = g.Stats()
+		cacheStats[i] = g.CacheStats()
+	}
+
+	counters := []struct {
+		name, help, typ string
+		value           func(i int) int64
+	}{
+		{"geecache_gets_total", "Get calls.", "counter", func(i int) int64 { return stats[i].Gets }},
+		{"geecache_hits_total", "Gets served from the local cache.", "counter", func(i int) int64 { return stats[i].Hits }},
+		{"geecache_misses_total", "Gets not found in the local cache.", "counter", func(i int) int64 { return stats[i].Misses }},
+		{"geecache_hot_hits_total", "Hits served from the hot cache of keys owned by other peers.", "counter", func(i int) int64 { return stats[i].HotHits }},
+		{"geecache_disk_hits_total", "Misses served from the disk tier.", "counter", func(i int) int64 { return stats[i].DiskHits }},
+		{"geecache_loads_total", "Loads including those deduplicated by singleflight.", "counter", func(i int) int64 { return stats[i].Loads }},
+		{"geecache_singleflight_dedups_total", "Loads that waited for an in-flight load of the same key.", "counter", func(i int) int64 { return stats[i].Dedups }},
+		{"geecache_overloaded_total", "Loads rejected because too many loads were in flight.", "counter", func(i int) int64 { return stats[i].Overloaded }},
+		{"geecache_rate_limited_total", "Requests rejected by rate limits.", "counter", func(i int) int64 { return stats[i].RateLimited }},
+		{"geecache_local_loads_total", "Successful Getter loads.", "counter", func(i int) int64 { return stats[i].LocalLoads }},
+		{"geecache_local_load_errors_total", "Failed Getter loads.", "counter", func(i int) int64 { return stats[i].LocalLoadErrs }},
+		{"geecache_peer_loads_total", "Successful loads from peers.", "counter", func(i int) int64 { return stats[i].PeerLoads }},
+		{"geecache_peer_errors_total", "Failed loads from peers.", "counter", func(i int) int64 { return stats[i].PeerErrors }},
+		{"geecache_evictions_total", "Entries evicted from the local cache.", "counter", func(i int) int64 { return cacheStats[i].Evictions }},
+		{"geecache_cache_bytes", "Bytes held in the local cache.", "gauge", func(i int) int64 { return cacheStats[i].Bytes }},
+		{"geecache_cache_max_bytes", "Capacity of the local cache in bytes (0 means unlimited).", "gauge", func(i int) int64 { return cacheStats[i].MaxBytes }},
+		{"geecache_cache_items", "Entries held in the local cache.", "gauge", func(i int) int64 { return cacheStats[i].Items }},
+		{"geecache_breaker_state", "State of the Getter circuit breaker (0 closed, 1 open, 2 half-open).", "gauge", func(i int) int64 { return int64(gs[i].breaker.State()) }},
+	}
+	for _, c := range counters {
+		writeHeader(w, c.name, c.help, c.typ)
+		for i, g := range gs {
+			fmt.Fprintf(w, "%s{group=%q} %d\n", c.name, g.name, c.value(i))
+		}
+	}
+
+	writeHeader(w, "geecache_getter_latency_seconds", "Latency of Getter calls.", "histogram")
+	for _, g := range gs {
+		writeHistogram(w, "geecache_getter_latency_seconds", g.name, g.metrics.getterLatency)
+	}
+	writeHeader(w, "geecache_peer_latency_seconds", "Latency of peer calls.", "histogram")
+	for _, g := range gs {
+		writeHistogram(w, "geecache_peer_latency_seconds", g.name, g.metrics.peerLatency)
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeHistogram 输出累积的桶计数以及 _sum、_count
+func writeHistogram(w *bufio.Writer, name, group string, h *histogram) {
+	var cumulative int64
+	for i, le := range h.buckets {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket{group=%q,le=%q} %d\n", name, group, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	cumulative += atomic.LoadInt64(&h.counts[len(h.buckets)])
+	fmt.Fprintf(w, "%s_bucket{group=%q,le=\"+Inf\"} %d\n", name, group, cumulative)
+	fmt.Fprintf(w, "%s_sum{group=%q} %g\n", name, group, time.Duration(atomic.LoadInt64(&h.sum)).Seconds())
+	fmt.Fprintf(w, "%s_count{group=%q} %d\n", name, group, atomic.LoadInt64(&h.count))
+}
@@ -1,6 +1,9 @@
 package geecache
 
-import pb "geecache/geecachepb"
+import (
+	"context"
+	pb "geecache/geecachepb"
+)
 
 //抽象出2个接口
 //为什么要这么做？抽象出这个2个接口有什么用
@@ -12,10 +15,48 @@ type PeerPicker interface {
 
 //知道缓存空间Group.name和Key 查找相应的value
 type PeerGetter interface {
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error //ctx 用于取消请求和传递追踪上下文
 	//Get(group string, key string) ([]byte, error)
 }
 
+// 标记请求由其他节点转发而来
+type forwardedKey struct{}
+
+// withForwarded 标记请求来自其他节点：本节点只在本地加载，不再选择节点，避免请求在节点之间来回转发
+func withForwarded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, true)
+}
+
+func isForwarded(ctx context.Context) bool {
+	forwarded, _ := ctx.Value(forwardedKey{}).(bool)
+	return forwarded
+}
+
+// 可选接口：为 key 按优先级选出多个副本节点，其中本节点用 nil 表示
+type ReplicaPicker interface {
+	PickReplicas(key string, n int) []PeerGetter
+}
+
+// 可选接口：返回所有远程节点，用于把热点 key 推送到每个节点
+type PeerLister interface {
+	ListPeers() []PeerGetter
+}
+
+// 把缓存值写入远程节点，用于节点变更时交接缓存
+type PeerSetter interface {
+	Set(ctx context.Context, in *pb.Request, value []byte) error
+}
+
+// 把缓存值写入远程节点的热点缓存
+type HotSetter interface {
+	SetHot(ctx context.Context, in *pb.Request, value []byte) error
+}
+
+// 删除远程节点上的缓存值，用于把失效广播到每个节点
+type PeerInvalidator interface {
+	Invalidate(ctx context.Context, in *pb.Request) error
+}
+
 /*
 类型总结
 key string
@@ -0,0 +1,90 @@
+package geecache
+
+/*
+预热：新节点启动时按 key 列表走正常的加载流程填充缓存，
+只预热一致性哈希分配给本节点的 key，并限制并发，避免压垮数据源
+*/
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PreloadProgress 预热进度
+type PreloadProgress struct {
+	Total   int //key 总数
+	Skipped int //由其他节点负责而跳过的 key
+	Loaded  int //加载成功
+	Failed  int //加载失败
+}
+
+// Preload 以最多 concurrency 个并发通过 Get 预热 keys，跳过由其他节点负责的 key。
+// progress 不为 nil 时每处理完一个 key 调用一次（串行调用）。ctx 取消后停止并返回 ctx.Err()
+func (g *Group) Preload(ctx context.Context, keys []string, concurrency int, progress func(PreloadProgress)) (PreloadProgress, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		lock sync.Mutex
+		p    = PreloadProgress{Total: len(keys)}
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, concurrency)
+	)
+	report := func(update func(p *PreloadProgress)) {
+		lock.Lock()
+		defer lock.Unlock()
+		update(&p)
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	for _, key := range keys {
+		if g.peers != nil {
+			if _, remote := g.peers.PickPeer(key); remote {
+				report(func(p *PreloadProgress) { p.Skipped++ })
+				continue
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return p, ctx.Err()
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := g.GetContext(ctx, key); err != nil {
+				g.logger.Debug("preload failed", "group", g.name, "key_hash", keyHash(key), "err", err)
+				report(func(p *PreloadProgress) { p.Failed++ })
+				return
+			}
+			report(func(p *PreloadProgress) { p.Loaded++ })
+		}(key)
+	}
+	wg.Wait()
+	return p, ctx.Err()
+}
+
+// LoadKeysFile 读取 key 列表文件，每行一个 key，忽略空行和 # 开头的注释
+func LoadKeysFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, scanner.Err()
+}
@@ -0,0 +1,168 @@
+package geecache
+
+/*
+内存压力控制：lru 的字节计数只是估算，进程实际占用的内存还包括 GC 的额外开销和其他对象。
+PressureController 定期通过 runtime/metrics 读取 Go 运行时占用的内存，与 debug.SetMemoryLimit 设置的上限比较：
+1、超过上限的 High 比例时，把所有受控 Group（或 MemoryManager 的总预算）按 ShrinkRatio 收缩
+2、低于 Low 比例时逐步恢复，直到原来的容量
+*/
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// PressureOptions 内存压力控制的参数，零值字段使用默认值
+type PressureOptions struct {
+	Interval    time.Duration //检查间隔，默认 1s
+	High        float64       //占用超过内存上限的该比例时收缩，默认 0.85
+	Low         float64       //占用低于内存上限的该比例时恢复，默认 0.7
+	ShrinkRatio float64       //每次收缩的比例，默认 0.1
+	MinScale    float64       //容量最多收缩到原来的该比例，默认 0.1
+}
+
+func (o PressureOptions) withDefaults() PressureOptions {
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.High <= 0 {
+		o.High = 0.85
+	}
+	if o.Low <= 0 {
+		o.Low = 0.7
+	}
+	if o.ShrinkRatio <= 0 || o.ShrinkRatio >= 1 {
+		o.ShrinkRatio = 0.1
+	}
+	if o.MinScale <= 0 {
+		o.MinScale = 0.1
+	}
+	return o
+}
+
+// PressureController 在内存压力下收缩缓存容量，并发安全
+type PressureController struct {
+	opts  PressureOptions
+	usage func() (used, limit int64) //读取内存占用和上限，测试时替换
+
+	lock     sync.Mutex
+	scale    float64                  //当前容量相对原来容量的比例
+	groups   map[*Group]int64         //受控的 Group 及其原来的容量
+	managers map[*MemoryManager]int64 //受控的 MemoryManager 及其原来的总预算
+}
+
+// NewPressureController 创建内存压力控制器，opts 为 nil 时使用默认参数
+func NewPressureController(opts *PressureOptions) *PressureController {
+	var o PressureOptions
+	if opts != nil {
+		o = *opts
+	}
+	return &PressureController{
+		opts:     o.withDefaults(),
+		usage:    runtimeMemory,
+		scale:    1,
+		groups:   make(map[*Group]int64),
+		managers: make(map[*MemoryManager]int64),
+	}
+}
+
+// Watch 控制 g 的容量，以当前容量作为原来的容量；不限制容量（0）的 Group 不受控制
+func (c *PressureController) Watch(g *Group) {
+	_, maxBytes, _, _ := g.mainCache.stats()
+	if maxBytes <= 0 {
+		return
+	}
+	c.lock.Lock()
+	c.groups[g] = maxBytes
+	c.lock.Unlock()
+	g.onClose(func() { //关闭的 Group 不再受控
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.groups, g)
+	})
+}
+
+// WatchManager 控制 m 的总预算，以当前总预算作为原来的预算。注册到 m 的 Group 不要再单独 Watch
+func (c *PressureController) WatchManager(m *MemoryManager) {
+	m.lock.Lock()
+	total := m.total
+	m.lock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.managers[m] = total
+}
+
+// Scale 返回当前容量相对原来容量的比例
+func (c *PressureController) Scale() float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.scale
+}
+
+// Check 读取一次内存占用，按需收缩或恢复容量。没有设置内存上限时不做调整
+func (c *PressureController) Check() {
+	used, limit := c.usage()
+	if limit <= 0 || limit == math.MaxInt64 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ratio := float64(used) / float64(limit)
+	scale := c.scale
+	switch {
+	case ratio >= c.opts.High:
+		scale = math.Max(c.opts.MinScale, scale*(1-c.opts.ShrinkRatio))
+	case ratio <= c.opts.Low:
+		scale = math.Min(1, scale/(1-c.opts.ShrinkRatio))
+	}
+	if scale == c.scale {
+		return
+	}
+	c.scale = scale
+	for g, base := range c.groups {
+		g.SetCacheBytes(scaled(base, scale))
+		g.logger.Info("memory pressure", "group", g.name, "used", used, "limit", limit, "scale", scale)
+	}
+	for m, base := range c.managers {
+		m.SetTotalBytes(scaled(base, scale))
+	}
+}
+
+// Run 每隔 Interval 调用一次 Check，直到 ctx 被取消
+func (c *PressureController) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check()
+		}
+	}
+}
+
+// scaled 按比例缩放容量，至少为 1（0 表示不限制容量）
+func scaled(base int64, scale float64) int64 {
+	if n := int64(float64(base) * scale); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// runtimeMemory 返回 Go 运行时占用的内存（与 GC 判断内存上限的口径一致）以及 debug.SetMemoryLimit 设置的上限
+func runtimeMemory() (used, limit int64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() == metrics.KindUint64 && samples[1].Value.Kind() == metrics.KindUint64 {
+		used = int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+	}
+	return used, debug.SetMemoryLimit(-1)
+}
@@ -0,0 +1,168 @@
+package geecache
+
+/*
+请求限速：令牌桶，每个 key（客户端 IP、认证后的身份或远程节点）一个桶，
+桶以 rate 个/秒的速度补充令牌，最多积累 burst 个，请求取不到令牌时返回 429 和 Retry-After。
+1、API 前端用 RateLimitHandler 按客户端限速
+2、HTTPPool.SetPeerRateLimit 按缓存空间对每个远程节点限速
+*/
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	bucketIdleTimeout = 10 * time.Minute //超过该时间没有请求的桶被清理
+	defaultMaxBuckets = 1 << 16          //最多跟踪的桶数，防止大量不同的 key 耗尽内存
+)
+
+// tokenBucket 一个令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time //上次补充令牌的时间
+}
+
+// RateLimiter 按 key 分别限速的令牌桶，并发安全
+type RateLimiter struct {
+	rate       float64 //每秒补充的令牌数
+	burst      float64 //桶的容量
+	maxBuckets int     //桶的数量上限
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time //上次清理空闲桶的时间
+}
+
+// NewRateLimiter 每个 key 每秒最多 rate 个请求，允许 burst 个突发请求
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), maxBuckets: defaultMaxBuckets,
+		buckets: make(map[string]*tokenBucket), swept: time.Now()}
+}
+
+// Allow 为 key 取一个令牌，取不到时返回 false 以及下一个令牌到来前需要等待的时间。
+// 桶的数量达到上限且清理不出空位时，新的 key 被拒绝，已有的 key 不受影响
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if now.Sub(l.swept) >= bucketIdleTimeout {
+		l.sweep(now, bucketIdleTimeout)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets {
+			l.sweep(now, l.refillTime())
+		}
+		if len(l.buckets) >= l.maxBuckets {
+			return false, time.Second
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep 清理空闲超过 idle 的桶。idle 不小于 refillTime 时它们已经装满，删除后再次请求时重新创建，结果相同
+func (l *RateLimiter) sweep(now time.Time, idle time.Duration) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= idle {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// refillTime 空桶装满需要的时间，最长为 bucketIdleTimeout
+func (l *RateLimiter) refillTime() time.Duration {
+	if l.rate <= 0 {
+		return bucketIdleTimeout
+	}
+	d := time.Duration(l.burst / l.rate * float64(time.Second))
+	if d > bucketIdleTimeout {
+		return bucketIdleTimeout
+	}
+	return d
+}
+
+// reject 返回 429，Retry-After 为向上取整的秒数
+func reject(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// ClientKey 限速使用的客户端标识：Authorizer 能确定身份时按身份，否则按客户端 IP。
+// 不直接使用请求中的令牌，否则客户端换一个伪造的令牌就能拿到新的令牌桶
+func ClientKey(a Authorizer, r *http.Request) string {
+	if a != nil {
+		if identity := a.Identify(r); identity != "" {
+			return "id:" + identity
+		}
+	}
+	return "ip:" + remoteHost(r)
+}
+
+// remoteHost 去掉 RemoteAddr 中的端口
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitHandler 按 ClientKey 对请求限速，超过限制时返回 429，被拒绝的请求计入 g 的指标（a、g 可以为 nil）。
+// 应放在权限检查之后，只有通过认证的请求才占用按身份划分的令牌桶
+func RateLimitHandler(l *RateLimiter, a Authorizer, g *Group, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(ClientKey(a, r)); !ok {
+			if g != nil {
+				g.metrics.rateLimited.Add(1)
+			}
+			reject(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetPeerRateLimit 对访问缓存空间 group 的每个远程节点限速，每秒最多 rate 个请求，允许 burst 个突发请求；
+// group 为 "*" 时作用于没有单独设置的缓存空间，rate <= 0 表示取消限速
+func (p *HTTPPool) SetPeerRateLimit(group string, rate float64, burst int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if rate <= 0 {
+		delete(p.rateLimits, group)
+		return
+	}
+	if p.rateLimits == nil {
+		p.rateLimits = make(map[string]*RateLimiter)
+	}
+	p.rateLimits[group] = NewRateLimiter(rate, burst)
+}
+
+// peerRateLimiter 返回缓存空间对应的限速器，没有设置时返回 nil
+func (p *HTTPPool) peerRateLimiter(group string) *RateLimiter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if l, ok := p.rateLimits[group]; ok {
+		return l
+	}
+	return p.rateLimits["*"]
+}
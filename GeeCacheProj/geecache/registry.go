@@ -0,0 +1,122 @@
+package geecache
+
+/*
+Group 的注册表：按名字管理缓存空间。
+创建 Group 的主要接口是 Registry.NewGroup，名字重复时返回 ErrGroupExists。
+NewGroup、GetGroup、DeleteGroup 等包级函数使用默认的全局注册表，其中 NewGroup 在名字重复时覆盖旧的 Group，
+测试或多租户进程可以用 NewRegistry 创建互相隔离的命名空间，并通过 HTTPPool.SetRegistry 对外提供服务
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrGroupExists 注册表中已有同名的 Group
+	ErrGroupExists = errors.New("geecache: group already exists")
+	// ErrGroupClosed Group 已经关闭
+	ErrGroupClosed = errors.New("geecache: group closed")
+)
+
+var defaultRegistry = NewRegistry()
+
+// Registry 按名字管理 Group，并发安全
+type Registry struct {
+	lock   sync.RWMutex
+	groups map[string]*Group
+}
+
+// DefaultRegistry 返回包级函数使用的全局注册表
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// NewRegistry 创建一个空的注册表
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// NewGroup 在注册表中创建 Group，同名的 Group 已存在时返回 ErrGroupExists
+func (r *Registry) NewGroup(name string, cacheBytes int64, getter Getter) (*Group, error) {
+	g := newGroup(name, cacheBytes, getter)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.groups[name]; ok {
+		g.cancel()
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	g.registry = r
+	r.groups[name] = g
+	return g, nil
+}
+
+// ReplaceGroup 在注册表中创建 Group，同名的旧 Group 被替换并关闭
+func (r *Registry) ReplaceGroup(name string, cacheBytes int64, getter Getter) *Group {
+	g := newGroup(name, cacheBytes, getter)
+	g.registry = r
+	r.lock.Lock()
+	old := r.groups[name]
+	r.groups[name] = g
+	r.lock.Unlock()
+	if old != nil {
+		old.Close() //旧 Group 已不在注册表中，Close 不会移除新的 Group
+	}
+	return g
+}
+
+// Get 返回名为 name 的 Group，不存在时返回 nil
+func (r *Registry) Get(name string) *Group {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.groups[name]
+}
+
+// Delete 从注册表中删除并关闭名为 name 的 Group，不存在时返回 false
+func (r *Registry) Delete(name string) bool {
+	g := r.Get(name)
+	if g == nil {
+		return false
+	}
+	g.Close()
+	return true
+}
+
+// Groups 按名字排序返回所有 Group，保证输出顺序稳定
+func (r *Registry) Groups() []*Group {
+	r.lock.RLock()
+	gs := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		gs = append(gs, g)
+	}
+	r.lock.RUnlock()
+	sort.Slice(gs, func(i, j int) bool { return gs[i].name < gs[j].name })
+	return gs
+}
+
+// put 把 g 加入注册表，覆盖同名的 Group
+func (r *Registry) put(g *Group) {
+	g.registry = r
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.groups[g.name] = g
+}
+
+// remove 在 g 关闭时从注册表中移除，名字已被新的 Group 占用时不做任何事
+func (r *Registry) remove(g *Group) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.groups[g.name] == g {
+		delete(r.groups, g.name)
+	}
+}
+
+// SetRegistry 设置 HTTPPool 查找缓存空间的注册表，传入 nil 恢复默认的全局注册表，需要在开始服务之前调用
+func (p *HTTPPool) SetRegistry(r *Registry) {
+	if r == nil {
+		r = defaultRegistry
+	}
+	p.registry = r
+}
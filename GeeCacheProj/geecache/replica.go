@@ -0,0 +1,71 @@
+package geecache
+
+/*
+副本：每个 key 由一致性哈希上的 R 个不同节点负责，按优先级排列。
+1、读取时依次询问副本节点，第一个正常返回的即为结果；轮到本节点时在本地加载，不再询问排在后面的副本，
+   这样请求只会发往优先级更高的副本，副本之间不会互相等待
+2、本节点加载成功后，把缓存值异步写入排在后面的副本，负责节点宕机时下一个副本仍有缓存
+*/
+
+import (
+	"context"
+	pb "geecache/geecachepb"
+	"time"
+)
+
+const replicateTimeout = 5 * time.Second //写入一个副本的超时时间
+
+// SetReplicas 设置副本数，n <= 1 表示每个 key 只有一个负责节点（默认）。
+// 需要注册的 PeerPicker 实现 ReplicaPicker，例如 HTTPPool
+func (g *Group) SetReplicas(n int) {
+	g.replicas = n
+}
+
+// owners 返回 key 的副本节点，本节点为 nil；未开启副本时退化为 PickPeer
+func (g *Group) owners(key string) []PeerGetter {
+	if picker, ok := g.peers.(ReplicaPicker); ok && g.replicas > 1 {
+		//副本都不可用时返回空列表，在本地加载
+		return picker.PickReplicas(key, g.replicas)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []PeerGetter{peer}
+	}
+	return nil
+}
+
+// replicate 把本地加载的缓存值写入其他副本
+func (g *Group) replicate(key string, value ByteView, peers []PeerGetter) {
+	for _, peer := range peers {
+		setter, ok := peer.(PeerSetter)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(g.ctx, replicateTimeout)
+		err := setter.Set(ctx, &pb.Request{Group: g.name, Key: key}, value.b)
+		cancel()
+		if err != nil {
+			g.logger.Warn("failed to replicate", "group", g.name, "key_hash", keyHash(key), "err", err)
+		}
+	}
+}
+
+// PickReplicas 返回 key 的 n 个副本节点，按优先级排列，本节点为 nil，跳过熔断器打开的节点
+func (p *HTTPPool) PickReplicas(key string, n int) []PeerGetter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	nodes := p.peers.GetN(key, n)
+	peers := make([]PeerGetter, 0, len(nodes))
+	for _, node := range nodes {
+		if node == p.self {
+			peers = append(peers, nil)
+		} else if getter := p.httpGetters[node]; getter.breaker.State() != BreakerOpen {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+var _ ReplicaPicker = (*HTTPPool)(nil)
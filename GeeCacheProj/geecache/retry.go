@@ -0,0 +1,181 @@
+package geecache
+
+/*
+节点请求的重试和对冲：
+1、重试：节点故障（网络错误或 502、503、504）时按带随机抖动的指数退避重试，避免所有节点在同一时刻重试
+2、对冲：请求耗时超过该节点历史延迟的某个分位（例如 p95）后，向另一个副本节点再发一个请求，
+   没有其他副本或者副本是本节点时在本地调用 Getter，先成功的结果生效，另一个请求被取消。用少量额外请求削掉长尾延迟
+*/
+
+import (
+	"context"
+	pb "geecache/geecachepb"
+	"math/rand"
+	"time"
+)
+
+const hedgeMinSamples = 20 //延迟样本少于该值时不对冲
+
+// RetryPolicy 重试策略，零值字段使用默认值
+type RetryPolicy struct {
+	MaxAttempts int           //最多尝试次数（包括第一次），默认 3
+	BaseDelay   time.Duration //第一次重试前的退避时间，之后每次翻倍，默认 10ms
+	MaxDelay    time.Duration //退避时间上限，默认 1s
+}
+
+// backoff 第 attempt 次重试前的退避时间，在 [d/2, d) 之间随机
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := r.BaseDelay, r.MaxDelay
+	if base <= 0 {
+		base = 10 * time.Millisecond
+	}
+	if max <= 0 {
+		max = time.Second
+	}
+	d := base << uint(attempt-1)
+	if d > max || d <= 0 {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// HedgePolicy 对冲策略，零值字段使用默认值
+type HedgePolicy struct {
+	Percentile float64       //请求耗时超过该分位的历史延迟时对冲，默认 0.95
+	MinDelay   time.Duration //对冲前至少等待的时间，默认 10ms
+}
+
+// SetRetryPolicy 设置节点请求的重试策略，传入 nil 表示不重试（默认）。需要在 Set 之前调用
+func (p *HTTPPool) SetRetryPolicy(r *RetryPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.retry = r
+}
+
+// SetHedging 开启对冲请求，传入 nil 表示关闭（默认）。需要在 Set 之前调用
+func (p *HTTPPool) SetHedging(h *HedgePolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.hedge = h
+}
+
+// withRetry 调用 attempt，它返回可以重试的错误时按重试策略退避后再次调用
+func (h *httpGetter) withRetry(ctx context.Context, attempt func() (retry bool, err error)) error {
+	policy := h.pool.retry
+	max := 1
+	if policy != nil {
+		max = policy.MaxAttempts
+		if max <= 0 {
+			max = 3
+		}
+	}
+	for i := 1; ; i++ {
+		retry, err := attempt()
+		if err == nil || !retry || i >= max {
+			return err
+		}
+		delay := policy.backoff(i)
+		h.pool.logger.Debug("retry peer request", "peer", h.baseURL, "attempt", i, "delay", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// hedgeDelay 返回对冲前等待的时间，0 表示不对冲
+func (h *httpGetter) hedgeDelay() time.Duration {
+	policy := h.pool.hedge
+	if policy == nil || h.latency == nil || h.latency.Count() < hedgeMinSamples {
+		return 0
+	}
+	q, min := policy.Percentile, policy.MinDelay
+	if q <= 0 || q >= 1 {
+		q = 0.95
+	}
+	if min <= 0 {
+		min = 10 * time.Millisecond
+	}
+	if d := h.latency.Quantile(q); d > min {
+		return d
+	}
+	return min
+}
+
+// hedgeResult 对冲中一个请求的结果
+type hedgeResult struct {
+	value []byte
+	err   error
+}
+
+// hedgedGet 先请求本节点，等待 delay 后仍未返回时向下一个副本发起对冲请求，返回先成功的结果
+func (h *httpGetter) hedgedGet(ctx context.Context, in *pb.Request, out *pb.Response, delay time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() //返回后取消还在进行的请求
+	results := make(chan hedgeResult, 2)
+	get := func(fetch func() ([]byte, error)) {
+		value, err := fetch()
+		results <- hedgeResult{value, err}
+	}
+	go get(func() ([]byte, error) {
+		res := &pb.Response{}
+		err := h.withRetry(ctx, func() (bool, error) { return h.get(ctx, in, res) })
+		return res.Value, err
+	})
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if backup := h.pool.hedgeTarget(in, h); backup != nil {
+				pending++
+				h.pool.logger.Debug("hedge peer request", "peer", h.baseURL, "key_hash", keyHash(in.GetKey()), "delay", delay)
+				go get(func() ([]byte, error) { return backup(ctx, in) })
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				out.Value = r.value
+				return nil
+			}
+			err = r.err
+		}
+	}
+	return err
+}
+
+// hedgeTarget 返回发起对冲请求的函数：只发往持有该 key 副本的节点（Group 的副本数大于 1 时），
+// 没有可用的副本节点时在本地调用 Getter。对冲请求和其他节点请求一样带有 forwardedHeader，接收方在本地加载，
+// 不会再转给慢节点或者再次对冲。Group 不存在时返回 nil
+func (p *HTTPPool) hedgeTarget(in *pb.Request, primary *httpGetter) func(ctx context.Context, in *pb.Request) ([]byte, error) {
+	g := p.registry.Get(in.GetGroup())
+	if g == nil {
+		return nil
+	}
+	local := func(ctx context.Context, in *pb.Request) ([]byte, error) {
+		value, err := g.getLocally(ctx, in.GetKey()) //已在 load 的 singleflight 中，不能再调用 load
+		return value.b, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.peers == nil || g.replicas <= 1 {
+		return local
+	}
+	for _, node := range p.peers.GetN(in.GetKey(), g.replicas) {
+		if node == p.self {
+			return local
+		}
+		if getter := p.httpGetters[node]; getter != primary && getter.breaker.State() != BreakerOpen {
+			return func(ctx context.Context, in *pb.Request) ([]byte, error) {
+				res := &pb.Response{}
+				_, err := getter.get(ctx, in, res)
+				return res.Value, err
+			}
+		}
+	}
+	return local
+}
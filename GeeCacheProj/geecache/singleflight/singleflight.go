@@ -1,12 +1,45 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrGoexit fn 调用了 runtime.Goexit，等待的调用者收到该错误
+var ErrGoexit = errors.New("singleflight: fn called runtime.Goexit")
+
+// PanicError fn 发生 panic 时所有调用者收到的错误，包含 panic 的值和当时的调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte //只用于写日志，Error 不包含调用栈，避免通过错误响应泄露给客户端
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v", p.Value)
+}
 
 //正在进行或已经结束的请求
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} //请求结束时关闭
+	val  interface{}
+	err  error
+
+	waiters []waiter //DoChan 的调用者，请求结束时把结果发给它们
+}
+
+type waiter struct {
+	ch     chan<- Result
+	shared bool
+}
+
+// Result 一次调用的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool //为 true 表示结果来自其他调用者发起的请求，本调用者没有执行 fn
 }
 
 //管理不同key的请求call，在这个表里说明key正在被请求
@@ -15,35 +48,111 @@ type ManegeCall struct {
 	m    map[string]*call
 }
 
-//针对相同的key，无论Do被调用多少次，函数fn都只会被调用一次
-func (mc *ManegeCall) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
-	mc.lock.Lock()
-
+// join 返回 key 正在进行的请求；没有时创建一个，第二个返回值为 true 表示由本调用者发起，需要执行 fn。调用时持有锁
+func (mc *ManegeCall) join(key string) (*call, bool) {
 	//初始化
 	if mc.m == nil {
 		mc.m = make(map[string]*call)
 	}
 	//对哈希表进行读操作
 	if c, ok := mc.m[key]; ok {
-		mc.lock.Unlock()
-		c.wg.Wait() //阻塞，如果请求正在进行中，则等待
-		return c.val, c.err
-
+		return c, false
 	}
 	//对哈希表写操作
-	c := new(call)
-	c.wg.Add(1)   //锁加1，发起请求前枷锁
+	c := &call{done: make(chan struct{})}
 	mc.m[key] = c //添加到哈希表里，表明 key 已经有对应的请求在处理
-	mc.lock.Unlock()
+	return c, true
+}
 
+//针对相同的key，无论Do被调用多少次，函数fn都只会被调用一次。
+//shared 为 true 表示本次调用等待了其他调用者的请求，没有执行 fn
+func (mc *ManegeCall) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	mc.lock.Lock()
+	c, first := mc.join(key)
+	mc.lock.Unlock()
+	if !first {
+		<-c.done //阻塞，如果请求正在进行中，则等待
+		return c.val, c.err, true
+	}
 	//调用fn，发起请求
-	c.val, c.err = fn()
-	c.wg.Done() //锁减1 请求结束
+	mc.doCall(c, key, fn)
+	return c.val, c.err, false
+}
 
-	//删除操作
+// DoChan 与 Do 相同，但不阻塞，结果通过返回的 channel 发送
+func (mc *ManegeCall) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	mc.lock.Lock()
+	c, first := mc.join(key)
+	c.waiters = append(c.waiters, waiter{ch: ch, shared: !first})
+	mc.lock.Unlock()
+	if first {
+		go mc.doCall(c, key, fn)
+	}
+	return ch
+}
+
+// DoContext 与 Do 相同，但 ctx 结束时立即返回 ctx.Err()。
+// 离开的调用者不会取消请求，fn 在后台继续执行，其他调用者照常拿到结果
+func (mc *ManegeCall) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	mc.lock.Lock()
+	c, first := mc.join(key)
+	mc.lock.Unlock()
+	if first {
+		go mc.doCall(c, key, fn)
+	}
+	select {
+	case <-c.done:
+		return c.val, c.err, !first
+	case <-ctx.Done():
+		return nil, ctx.Err(), !first
+	}
+}
+
+// Forget 让 key 之后的调用重新执行 fn，而不是等待正在进行的请求；正在等待的调用者不受影响
+func (mc *ManegeCall) Forget(key string) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 	delete(mc.m, key)
+}
 
-	return c.val, c.err
+// doCall 执行 fn 并通知所有等待的调用者。
+// fn 发生 panic 时恢复并把 *PanicError 作为错误返回给所有调用者；fn 调用 runtime.Goexit 时执行 fn 的协程照常退出，
+// 其他调用者收到 ErrGoexit。无论哪种情况都会删除正在进行的请求，不会让之后的调用永远等待。
+// Go 1.21 之前 panic(nil) 和 Goexit 时 recover 都返回 nil，只能看内层函数是否返回来区分：Goexit 不会回到调用处
+func (mc *ManegeCall) doCall(c *call, key string, fn func() (interface{}, error)) {
+	returned := false //fn 正常返回或 panic 被恢复
+	defer func() {
+		if !returned { //只有 Goexit 会跳过下面的恢复直接到这里
+			c.val, c.err = nil, ErrGoexit
+		}
+		mc.finish(c, key)
+	}()
+	func() {
+		normal := false //fn 正常返回
+		defer func() {
+			if !normal { //panic（包括 panic(nil)）或 Goexit，Goexit 时的结果由外层覆盖为 ErrGoexit
+				c.val, c.err = nil, &PanicError{Value: recover(), Stack: debug.Stack()}
+			}
+		}()
+		c.val, c.err = fn()
+		normal = true
+	}()
+	returned = true
+}
+
+// finish 删除正在进行的请求并通知等待的调用者
+func (mc *ManegeCall) finish(c *call, key string) {
+	//删除操作，key 可能已被 Forget 并由新的请求占用
+	mc.lock.Lock()
+	if mc.m[key] == c {
+		delete(mc.m, key)
+	}
+	waiters := c.waiters
+	mc.lock.Unlock()
+
+	close(c.done) //请求结束
+	for _, w := range waiters {
+		w.ch <- Result{Val: c.val, Err: c.err, Shared: w.shared}
+	}
 }
@@ -0,0 +1,185 @@
+package singleflight
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var mc ManegeCall
+	v, err, shared := mc.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v", v, err, shared)
+	}
+}
+
+// 测试并发调用只执行一次 fn，只有发起请求的调用者 shared 为 false
+func TestDoDedup(t *testing.T) {
+	var mc ManegeCall
+	var calls, sharedCount int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := mc.Do("key", fn)
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			if shared {
+				atomic.AddInt32(&sharedCount, 1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) //等待所有调用者进入 Do
+	close(release)
+	wg.Wait()
+	if calls != 1 || sharedCount != n-1 {
+		t.Fatalf("expect 1 call and %d shared results, got %d calls and %d shared", n-1, calls, sharedCount)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var mc ManegeCall
+	release := make(chan struct{})
+	first := mc.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+	second := mc.DoChan("key", func() (interface{}, error) {
+		t.Error("second fn should not be called")
+		return nil, nil
+	})
+	close(release)
+	if r := <-first; r.Val != "bar" || r.Err != nil || r.Shared {
+		t.Fatalf("first result = %+v", r)
+	}
+	if r := <-second; r.Val != "bar" || !r.Shared {
+		t.Fatalf("second result = %+v", r)
+	}
+}
+
+// 测试 Forget：之后的调用重新执行 fn，原来的请求结束时不会删除新的请求
+func TestForget(t *testing.T) {
+	var mc ManegeCall
+	release := make(chan struct{})
+	first := mc.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	mc.Forget("key")
+	second := mc.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 2, nil
+	})
+	close(release)
+	if r1, r2 := <-first, <-second; r1.Val != 1 || r2.Val != 2 || r2.Shared {
+		t.Fatalf("after Forget got %+v and %+v", r1, r2)
+	}
+	if v, _, _ := mc.Do("key", func() (interface{}, error) { return 3, nil }); v != 3 {
+		t.Fatalf("expect a new call after both finished, got %v", v)
+	}
+}
+
+// 测试 DoContext：等待者离开不影响正在进行的请求
+func TestDoContext(t *testing.T) {
+	var mc ManegeCall
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := mc.DoChan("key", fn)
+	errc := make(chan error)
+	go func() {
+		_, err, _ := mc.DoContext(ctx, "key", fn)
+		errc <- err
+	}()
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("cancelled waiter got %v", err)
+	}
+	close(release)
+	if r := <-result; r.Val != "bar" || r.Err != nil {
+		t.Fatalf("shared call should finish after a waiter left, got %+v", r)
+	}
+}
+
+// 测试 panic：所有调用者收到 PanicError，请求被删除，之后的调用重新执行 fn
+func TestPanic(t *testing.T) {
+	var mc ManegeCall
+	release := make(chan struct{})
+	waiter := mc.DoChan("key", func() (interface{}, error) {
+		<-release
+		panic("boom")
+	})
+	second := mc.DoChan("key", func() (interface{}, error) { return nil, nil })
+	close(release)
+	for _, ch := range []<-chan Result{waiter, second} {
+		r := <-ch
+		if pe, ok := r.Err.(*PanicError); !ok || pe.Value != "boom" || len(pe.Stack) == 0 {
+			t.Fatalf("expect PanicError, got %v", r.Err)
+		}
+		if msg := r.Err.Error(); msg != "singleflight: fn panicked: boom" {
+			t.Fatalf("Error should not include the stack, got %q", msg)
+		}
+	}
+	_, err, _ := mc.Do("key", func() (interface{}, error) { panic("again") })
+	if _, ok := err.(*PanicError); !ok {
+		t.Fatalf("Do should return PanicError, got %v", err)
+	}
+	if v, err, _ := mc.Do("key", func() (interface{}, error) { return "bar", nil }); v != "bar" || err != nil {
+		t.Fatalf("call after panic = %v, %v", v, err)
+	}
+}
+
+// 测试 panic(nil)：Go 1.21 之前 recover 返回 nil，仍然按 panic 处理，不能当作 Goexit
+func TestPanicNil(t *testing.T) {
+	var mc ManegeCall
+	_, err, _ := mc.Do("key", func() (interface{}, error) { panic(nil) })
+	if _, ok := err.(*PanicError); !ok {
+		t.Fatalf("panic(nil) should return PanicError, got %v", err)
+	}
+}
+
+// 测试 runtime.Goexit：执行 fn 的协程退出，等待者收到 ErrGoexit，请求被删除
+func TestGoexit(t *testing.T) {
+	var mc ManegeCall
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		mc.Do("key", func() (interface{}, error) {
+			close(entered)
+			<-release
+			runtime.Goexit()
+			return nil, nil
+		})
+		t.Error("Do should not return after Goexit")
+	}()
+	<-entered
+	waiter := mc.DoChan("key", func() (interface{}, error) { return nil, nil })
+	close(release)
+	<-exited
+	if r := <-waiter; r.Err != ErrGoexit || !r.Shared {
+		t.Fatalf("waiter got %+v, expect ErrGoexit", r)
+	}
+	if v, _, _ := mc.Do("key", func() (interface{}, error) { return "bar", nil }); v != "bar" {
+		t.Fatalf("call after Goexit = %v", v)
+	}
+}
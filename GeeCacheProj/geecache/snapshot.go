@@ -0,0 +1,163 @@
+package geecache
+
+/*
+缓存快照：把 Group 本地缓存的内容按 LRU 顺序写入二进制格式，重启后恢复，避免冷启动。
+格式（整数均为 varint，除非特别说明）：
+
+	magic     "GEESNAP"
+	version   1 字节
+	group     长度 + 缓存空间名字
+	count     缓存项数量
+	entries   count 个 {key 长度, key, value 长度, value, 过期时间（unix 纳秒，0 表示不过期）}，从最久未访问到最近访问
+	checksum  前面所有字节的 CRC32（IEEE），4 字节大端
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	snapshotMagic   = "GEESNAP"
+	snapshotVersion = 1
+	maxSnapshotItem = 64 << 20 //单个 key 或 value 的长度上限，防止损坏的快照申请过大的内存
+)
+
+var errBadSnapshot = errors.New("geecache: corrupt snapshot")
+
+// snapshotEntry 快照中的一项
+type snapshotEntry struct {
+	key    string
+	value  ByteView
+	expire int64 //unix 纳秒，0 表示不过期
+}
+
+// Snapshot 将本地缓存的内容写入 w，保留 LRU 顺序
+func (g *Group) Snapshot(w io.Writer) error {
+	entries := g.mainCache.entries()
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var buf [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], x)])
+	}
+
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	putUvarint(uint64(len(g.name)))
+	bw.WriteString(g.name)
+	putUvarint(uint64(len(entries)))
+	for _, e := range entries {
+		putUvarint(uint64(len(e.key)))
+		bw.WriteString(e.key)
+		putUvarint(uint64(e.value.Len()))
+		bw.Write(e.value.b)
+		bw.Write(buf[:binary.PutVarint(buf[:], e.expire)])
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// Restore 从 r 读取 Snapshot 写入的快照并加入本地缓存。
+// 校验和通过之后才会修改缓存，已过期的项被丢弃
+func (g *Group) Restore(r io.Reader) error {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := &byteTee{r: br, w: crc}
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(tr, magic); err != nil || string(magic) != snapshotMagic {
+		return errBadSnapshot
+	}
+	version, err := tr.ReadByte()
+	if err != nil {
+		return errBadSnapshot
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("geecache: unsupported snapshot version %d", version)
+	}
+	name, err := readBytes(tr)
+	if err != nil {
+		return err
+	}
+	if string(name) != g.name {
+		return fmt.Errorf("geecache: snapshot belongs to group %q, not %q", name, g.name)
+	}
+	count, err := binary.ReadUvarint(tr)
+	if err != nil {
+		return errBadSnapshot
+	}
+	var entries []snapshotEntry
+	for i := uint64(0); i < count; i++ {
+		key, err := readBytes(tr)
+		if err != nil {
+			return err
+		}
+		value, err := readBytes(tr)
+		if err != nil {
+			return err
+		}
+		expire, err := binary.ReadVarint(tr)
+		if err != nil {
+			return errBadSnapshot
+		}
+		entries = append(entries, snapshotEntry{key: string(key), value: ByteView{b: value}, expire: expire})
+	}
+
+	expect := crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil || binary.BigEndian.Uint32(sum[:]) != expect {
+		return errBadSnapshot
+	}
+
+	now := time.Now().UnixNano()
+	for _, e := range entries { //按从旧到新的顺序加入，恢复 LRU 顺序
+		if e.expire != 0 && e.expire <= now {
+			continue
+		}
+		g.populateCache(e.key, e.value)
+	}
+	return nil
+}
+
+func readBytes(r *byteTee) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > maxSnapshotItem {
+		return nil, errBadSnapshot
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errBadSnapshot
+	}
+	return b, nil
+}
+
+// byteTee 将读到的字节同时写入 w（用于计算校验和），并实现 io.ByteReader 以便读取 varint
+type byteTee struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func (t *byteTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.w.Write(p[:n])
+	return n, err
+}
+
+func (t *byteTee) ReadByte() (byte, error) {
+	c, err := t.r.ReadByte()
+	if err == nil {
+		t.w.Write([]byte{c})
+	}
+	return c, err
+}
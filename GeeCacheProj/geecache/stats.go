@@ -0,0 +1,126 @@
+package geecache
+
+// Stats 是 Group 计数器的快照
+type Stats struct {
+	Gets          int64 `json:"gets"`            //Get 调用次数
+	Hits          int64 `json:"hits"`            //命中本地缓存
+	Misses        int64 `json:"misses"`          //未命中，需要加载
+	HotHits       int64 `json:"hot_hits"`        //命中热点缓存（包含在 Hits 中）
+	DiskHits      int64 `json:"disk_hits"`       //命中磁盘二级缓存
+	Loads         int64 `json:"loads"`           //进入 load 的次数（包含被 singleflight 合并的）
+	Dedups        int64 `json:"dedups"`          //被 singleflight 合并的加载
+	Overloaded    int64 `json:"overloaded"`      //并发加载过多而被拒绝的加载
+	RateLimited   int64 `json:"rate_limited"`    //超过限速而被拒绝的请求
+	LocalLoads    int64 `json:"local_loads"`     //调用 Getter 成功加载
+	LocalLoadErrs int64 `json:"local_load_errs"` //调用 Getter 失败
+	PeerLoads     int64 `json:"peer_loads"`      //从远程节点成功加载
+	PeerErrors    int64 `json:"peer_errors"`     //从远程节点加载失败
+}
+
+// HitRatio 返回命中率，没有 Get 调用时为 0
+func (s Stats) HitRatio() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+func (s *Stats) add(o Stats) {
+	s.Gets += o.Gets
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.HotHits += o.HotHits
+	s.DiskHits += o.DiskHits
+	s.Loads += o.Loads
+	s.Dedups += o.Dedups
+	s.Overloaded += o.Overloaded
+	s.RateLimited += o.RateLimited
+	s.LocalLoads += o.LocalLoads
+	s.LocalLoadErrs += o.LocalLoadErrs
+	s.PeerLoads += o.PeerLoads
+	s.PeerErrors += o.PeerErrors
+}
+
+// CacheStats 是本地缓存占用情况的快照
+type CacheStats struct {
+	Bytes     int64 `json:"bytes"`     //占用内存
+	MaxBytes  int64 `json:"max_bytes"` //最大缓存，0 表示不限制
+	Items     int64 `json:"items"`     //缓存项数量
+	Evictions int64 `json:"evictions"` //因容量不足被淘汰的次数
+}
+
+func (s *CacheStats) add(o CacheStats) {
+	s.Bytes += o.Bytes
+	s.MaxBytes += o.MaxBytes
+	s.Items += o.Items
+	s.Evictions += o.Evictions
+}
+
+// Stats 返回 Group 当前计数器的快照
+func (g *Group) Stats() Stats {
+	m := g.metrics
+	return Stats{
+		Gets:          m.gets.Get(),
+		Hits:          m.hits.Get(),
+		Misses:        m.misses.Get(),
+		HotHits:       m.hotHits.Get(),
+		DiskHits:      m.diskHits.Get(),
+		Loads:         m.loads.Get(),
+		Dedups:        m.dedups.Get(),
+		Overloaded:    m.overloaded.Get(),
+		RateLimited:   m.rateLimited.Get(),
+		LocalLoads:    m.localLoads.Get(),
+		LocalLoadErrs: m.localErrs.Get(),
+		PeerLoads:     m.peerLoads.Get(),
+		PeerErrors:    m.peerErrs.Get(),
+	}
+}
+
+// CacheStats 返回本地缓存占用情况
+func (g *Group) CacheStats() CacheStats {
+	bytes, maxBytes, items, evictions := g.mainCache.stats()
+	return CacheStats{Bytes: bytes, MaxBytes: maxBytes, Items: items, Evictions: evictions}
+}
+
+// GroupStats 一个缓存空间的全部统计，用于管理接口的 JSON 输出
+type GroupStats struct {
+	Stats    Stats      `json:"stats"`
+	Cache    CacheStats `json:"cache"`
+	HitRatio float64    `json:"hit_ratio"`
+	Breaker  string     `json:"breaker,omitempty"` //Getter 熔断器的状态，汇总时为空
+}
+
+func (s *GroupStats) add(o GroupStats) {
+	s.Stats.add(o.Stats)
+	s.Cache.add(o.Cache)
+	s.HitRatio = s.Stats.HitRatio()
+	s.Breaker = ""
+}
+
+// NodeStats 一个节点上所有缓存空间的统计
+type NodeStats struct {
+	Node   string                `json:"node"`
+	Groups map[string]GroupStats `json:"groups,omitempty"`
+	Peers  map[string]string     `json:"peers,omitempty"` //该节点上各远程节点熔断器的状态
+	Error  string                `json:"error,omitempty"` //向该节点拉取统计失败的原因
+}
+
+// ClusterStats 集群内所有节点的统计以及按缓存空间的汇总
+type ClusterStats struct {
+	Nodes  []NodeStats           `json:"nodes"`
+	Groups map[string]GroupStats `json:"groups"`
+}
+
+// localStats 收集本节点 gs 中所有缓存空间的统计
+func localStats(node string, gs []*Group) NodeStats {
+	ns := NodeStats{Node: node, Groups: make(map[string]GroupStats)}
+	for _, g := range gs {
+		stats := g.Stats()
+		group := GroupStats{Stats: stats, Cache: g.CacheStats(), HitRatio: stats.HitRatio()}
+		if g.breaker != nil {
+			group.Breaker = g.breaker.State().String()
+		}
+		ns.Groups[g.name] = group
+	}
+	return ns
+}
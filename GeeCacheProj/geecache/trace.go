@@ -0,0 +1,63 @@
+package geecache
+
+/*
+分布式追踪的钩子：Group.Get、load、getLocally、getFromPeer 以及 HTTPPool.ServeHTTP 各自创建一个 span，
+接口形状与 OpenTelemetry 的 Tracer/Span 一致，可以很容易地包装 OpenTelemetry 的实现，
+本身不依赖任何第三方库，默认什么都不做。
+追踪上下文通过 Inject 写入 httpGetter 发出的请求头，在 ServeHTTP 中通过 Extract 取出
+*/
+
+import (
+	"context"
+	"net/http"
+)
+
+// Attribute span 上的一个属性
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr 创建一个属性
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span 一段被追踪的操作
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer 创建 span 并在节点之间传递追踪上下文
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+	Inject(ctx context.Context, header http.Header)                  //把 ctx 中的追踪上下文写入请求头
+	Extract(ctx context.Context, header http.Header) context.Context //从请求头中取出追踪上下文
+}
+
+// nopTracer 不做任何事情，是 Group 和 HTTPPool 的默认值
+type nopTracer struct{}
+
+type nopSpan struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+func (nopTracer) Inject(ctx context.Context, header http.Header) {}
+func (nopTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	return ctx
+}
+
+func (nopSpan) SetAttributes(attrs ...Attribute) {}
+func (nopSpan) RecordError(err error)            {}
+func (nopSpan) End()                             {}
+
+// endSpan 记录错误（如果有）并结束 span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
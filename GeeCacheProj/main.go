@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"geecache"
+	"geecache/disk"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
 )
 
+var logger geecache.Logger
+
 var db = map[string]string{
 	"Tom":  "630",
 	"Jack": "589",
@@ -26,33 +38,201 @@ func createGroup() *geecache.Group {
 		}))
 }
 
-// startCacheServer() 用来启动缓存服务器：创建 HTTPPool，添加节点信息，注册到 gee 中，启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知。
-func startCacheServer(addr string, addrs []string, gee *geecache.Group) { //addr 服务器地址 addrs包含了其他节点地址的切片列表
+// newCacheServer() 用来创建缓存服务器：创建 HTTPPool，添加节点信息，注册到 gee 中，由 startCacheServer 启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知。
+// configure 不为 nil 时在添加节点之前调用，用于设置熔断等 HTTPPool 选项
+func newCacheServer(addr string, addrs map[string]int, gee *geecache.Group, tlsOpts geecache.TLSOptions, secret string, authz geecache.Authorizer, configure func(*geecache.HTTPPool)) *http.Server { //addr 服务器地址 addrs包含了其他节点地址及其权重
 	peers := geecache.NewHTTPPool(addr)
-	peers.Set(addrs...)
+	peers.SetLogger(logger)
+	if configure != nil {
+		configure(peers)
+	}
+	if secret != "" {
+		peers.SetSecret([]byte(secret)) //节点间请求使用共享密钥签名
+	}
+	peers.SetAuthorizer(authz)
+	if secret == "" && !tlsOpts.RequireClientCert && authz == nil {
+		logger.Warn("peer writes are rejected without -secret, -mtls or -acl; handoff, replication and hot key pushes are disabled")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/_geecache/", peers)                      //节点间通信
+	mux.Handle("/metrics", geecache.MetricsHandler())     //Prometheus 指标
+	mux.Handle("/_geecache_admin/", peers.AdminHandler()) //统计等管理接口
+	server := &http.Server{Addr: hostOf(addr), Handler: mux}
+	if tlsOpts.CertFile != "" {
+		if err := peers.SetTLS(tlsOpts); err != nil {
+			log.Fatal(err)
+		}
+		cfg, err := tlsOpts.ServerConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = cfg
+	}
+	peers.SetWeighted(addrs)
 	gee.RegisterPeers(peers)
-	log.Println("geecache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	return server
+}
+
+// startCacheServer() 启动 HTTP 服务，收到 SIGINT/SIGTERM 后优雅退出，处理中的请求完成后才返回
+func startCacheServer(server *http.Server) {
+	done := make(chan struct{})
+	go shutdownOnSignal(server, done)
+	log.Println("geecache is running at", server.Addr)
+	var err error
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "") //证书已在 TLSConfig 中
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	//Shutdown 一开始 ListenAndServe 就返回 ErrServerClosed，要等处理中的请求完成后再保存快照、释放缓存
+	<-done
+}
+
+// shutdownOnSignal 收到退出信号后停止接收新请求，等待处理中的请求完成后关闭 done
+func shutdownOnSignal(server *http.Server, done chan<- struct{}) {
+	defer close(done)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("shutdown:", err)
+	}
+}
+
+// preload 按 key 列表预热本节点负责的 key
+func preload(gee *geecache.Group, path string, concurrency int) {
+	keys, err := geecache.LoadKeysFile(path)
+	if err != nil {
+		log.Println("preload:", err)
+		return
+	}
+	start := time.Now()
+	p, _ := gee.Preload(context.Background(), keys, concurrency, func(p geecache.PreloadProgress) {
+		if done := p.Skipped + p.Loaded + p.Failed; done%1000 == 0 {
+			log.Printf("preload progress: %d/%d", done, p.Total)
+		}
+	})
+	log.Printf("preload finished in %v: %d loaded, %d failed, %d owned by other peers",
+		time.Since(start), p.Loaded, p.Failed, p.Skipped)
+}
+
+// restoreSnapshot 启动时从快照文件恢复缓存，文件不存在时跳过
+func restoreSnapshot(gee *geecache.Group, path string) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := gee.Restore(f); err != nil {
+		log.Println("restore snapshot:", err) //快照损坏时冷启动
+		return
+	}
+	log.Println("restored snapshot from", path)
+}
+
+// saveSnapshot 先写临时文件再重命名，避免退出时写了一半的快照覆盖旧快照
+func saveSnapshot(gee *geecache.Group, path string) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Println("save snapshot:", err)
+		return
+	}
+	err = gee.Snapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Println("save snapshot:", err)
+		return
+	}
+	log.Println("saved snapshot to", path)
+}
 
+// loadACL 读取访问控制配置
+func loadACL(path string) *geecache.ACL {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	acl, err := geecache.LoadACL(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return acl
+}
+
+// parseWeights 解析 "8001=1,8002=8" 形式的节点权重
+func parseWeights(s string) map[int]int {
+	weights := make(map[int]int)
+	if s == "" {
+		return weights
+	}
+	for _, kv := range strings.Split(s, ",") {
+		var port, weight int
+		if _, err := fmt.Sscanf(kv, "%d=%d", &port, &weight); err != nil {
+			log.Fatalf("bad weight %q: %v", kv, err)
+		}
+		weights[port] = weight
+	}
+	return weights
+}
+
+// hostOf 去掉 http:// 或 https:// 前缀，得到监听地址
+func hostOf(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return u.Host
 }
 
 //startAPIServer() 用来启动一个 API 服务（端口 9999），与用户进行交互，用户感知。
 //从给定的 geecache.Group 对象中检索缓存数据，并通过 HTTP 接口将数据返回给客户端。
 
-func startAPIServer(apiAddr string, gee *geecache.Group) { //apiAddr 服务器地址
-	http.Handle("/api", http.HandlerFunc( //注册了一个处理函数，用于处理 /api 路径的 HTTP 请求
+// limiter 不为 nil 时按 API 令牌或客户端 IP 限速
+func startAPIServer(apiAddr string, gee *geecache.Group, authz geecache.Authorizer, limiter *geecache.RateLimiter) { //apiAddr 服务器地址
+	var handler http.Handler = http.HandlerFunc( //处理 /api 路径的 HTTP 请求
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key") //通过 r.URL.Query().Get("key") 获取请求 URL 中的查询参数 key
 			view, err := gee.Get(key)
+			if errors.Is(err, geecache.ErrOverloaded) { //加载过多，让客户端稍后重试
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			w.Header().Set("Content-Type", "application/octet-stream")
 			w.Write(view.ByteSlice())
-		}))
+		})
+	if limiter != nil {
+		handler = geecache.RateLimitHandler(limiter, authz, gee, handler)
+	}
+	//先检查权限再限速：伪造的令牌在这里被拒绝，不会占用按身份划分的令牌桶
+	http.Handle("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !geecache.Authorize(authz, logger, r, gee.Name(), geecache.OpRead) { //按令牌检查是否有读权限
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})) //注册处理函数
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil)) //apiAddr[7:] 去掉 http:// 前缀，以获得适当的地址格式。服务将在该地址运行，接受并处理 HTTP 请求
+	log.Fatal(http.ListenAndServe(hostOf(apiAddr), nil)) //去掉 http:// 前缀，以获得适当的地址格式。服务将在该地址运行，接受并处理 HTTP 请求
 }
 
 func main() {
@@ -61,22 +241,148 @@ func main() {
 	var api bool
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	//节点间通信的认证：TLS/mTLS 证书或共享密钥
+	var tlsOpts geecache.TLSOptions
+	var secret string
+	flag.StringVar(&tlsOpts.CertFile, "cert", "", "TLS certificate file for peer traffic")
+	flag.StringVar(&tlsOpts.KeyFile, "key", "", "TLS key file for peer traffic")
+	flag.StringVar(&tlsOpts.CAFile, "ca", "", "CA file used to verify peers")
+	flag.BoolVar(&tlsOpts.RequireClientCert, "mtls", false, "Require client certificates from peers")
+	flag.StringVar(&secret, "secret", "", "Shared cluster secret for signing peer requests")
+	var aclFile string
+	flag.StringVar(&aclFile, "acl", "", "JSON file mapping API tokens and identities to allowed groups")
+	var verbose bool
+	flag.BoolVar(&verbose, "v", false, "Log cache hits and peer traffic")
+	var snapshot string
+	flag.StringVar(&snapshot, "snapshot", "", "Restore the cache from this file on startup and save it on shutdown")
+	var diskPath string
+	var diskBytes int64
+	flag.StringVar(&diskPath, "disk", "", "Log file of the on-disk cache tier")
+	flag.Int64Var(&diskBytes, "diskbytes", 64<<20, "Byte budget of the on-disk cache tier")
+	var preloadFile string
+	var preloadConcurrency int
+	flag.StringVar(&preloadFile, "preload", "", "File of keys (one per line) to warm up on startup")
+	flag.IntVar(&preloadConcurrency, "preload-concurrency", 8, "Concurrent loads while warming up")
+	var weightList string
+	flag.StringVar(&weightList, "weights", "", "Peer weights by port, e.g. 8001=1,8002=8 (default 1)")
+	var replicas int
+	flag.IntVar(&replicas, "replicas", 1, "Number of peers each key is replicated to")
+	var hotKeys int
+	var hotThreshold int64
+	flag.IntVar(&hotKeys, "hotkeys", 0, "Track the K most requested keys (0 disables)")
+	flag.Int64Var(&hotThreshold, "hot-threshold", 1000, "Requests per minute that make a key hot and replicated to every peer")
+	var breakers bool
+	flag.BoolVar(&breakers, "breaker", false, "Fail fast with circuit breakers on the Getter and on each peer")
+	var retries int
+	var hedge bool
+	flag.IntVar(&retries, "retries", 1, "Attempts per peer request, retried with jittered exponential backoff")
+	flag.BoolVar(&hedge, "hedge", false, "Hedge peer requests slower than their p95 latency to the next replica")
+	var maxLoads, loadQueue int
+	flag.IntVar(&maxLoads, "max-loads", 0, "Maximum concurrent loads (0 means unlimited)")
+	flag.IntVar(&loadQueue, "load-queue", 100, "Loads allowed to wait when -max-loads is reached")
+	var apiRate, peerRate float64
+	var apiBurst, peerBurst int
+	flag.Float64Var(&apiRate, "api-rate", 0, "Requests per second allowed per API token or client IP (0 means unlimited)")
+	flag.IntVar(&apiBurst, "api-burst", 20, "Burst of API requests allowed above -api-rate")
+	flag.Float64Var(&peerRate, "peer-rate", 0, "Requests per second allowed per peer (0 means unlimited)")
+	flag.IntVar(&peerBurst, "peer-burst", 100, "Burst of peer requests allowed above -peer-rate")
+	var memory, memoryLimit int64
+	flag.Int64Var(&memory, "memory", 0, "Byte budget shared by all groups and rebalanced by hit rate (0 keeps each group's own size)")
+	flag.Int64Var(&memoryLimit, "memory-limit", 0, "Soft memory limit of the process; caches shrink as the heap approaches it (0 disables)")
 	flag.Parse()
 
+	//默认只输出 info 及以上级别的日志，-v 时输出每次命中和节点请求
+	level := geecache.LevelInfo
+	if verbose {
+		level = geecache.LevelDebug
+	}
+	logger = geecache.NewStdLogger(log.Default(), level)
+
+	//访问控制：未指定 -acl 时不做限制
+	var authz geecache.Authorizer
+	if aclFile != "" {
+		authz = loadACL(aclFile)
+	}
+
+	scheme := "http"
+	if tlsOpts.CertFile != "" {
+		scheme = "https"
+	}
 	apiAddr := "http://localhost:9999"
 	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+		8001: scheme + "://localhost:8001",
+		8002: scheme + "://localhost:8002",
+		8003: scheme + "://localhost:8003",
 	}
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	weights := parseWeights(weightList)
+	addrs := make(map[string]int, len(addrMap))
+	for p, v := range addrMap {
+		addrs[v] = 1
+		if w, ok := weights[p]; ok {
+			addrs[v] = w
+		}
 	}
 
 	gee := createGroup()
+	gee.SetLogger(logger)
+	gee.SetReplicas(replicas)
+	gee.SetHotKeys(hotKeys, hotThreshold)
+	if breakers {
+		gee.SetBreaker(&geecache.BreakerOptions{})
+	}
+	gee.SetLoadLimit(maxLoads, loadQueue)
+	var manager *geecache.MemoryManager
+	if memory > 0 {
+		manager = geecache.NewMemoryManager(memory) //所有 Group 共享内存预算
+		manager.Register(gee, 1)
+		go manager.Run(context.Background(), 10*time.Second)
+	}
+	if memoryLimit > 0 {
+		debug.SetMemoryLimit(memoryLimit)
+		pressure := geecache.NewPressureController(nil) //接近内存上限时收缩缓存
+		if manager != nil {
+			pressure.WatchManager(manager)
+		} else {
+			pressure.Watch(gee)
+		}
+		go pressure.Run(context.Background())
+	}
+	if diskPath != "" {
+		store, err := disk.Open(diskPath, diskBytes) //内存淘汰的缓存项降级到磁盘
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		gee.SetDiskTier(store)
+	}
+	if snapshot != "" {
+		restoreSnapshot(gee, snapshot)
+	}
 	if api {
-		go startAPIServer(apiAddr, gee)
+		var limiter *geecache.RateLimiter
+		if apiRate > 0 {
+			limiter = geecache.NewRateLimiter(apiRate, apiBurst)
+		}
+		go startAPIServer(apiAddr, gee, authz, limiter)
+	}
+	server := newCacheServer(addrMap[port], addrs, gee, tlsOpts, secret, authz, func(p *geecache.HTTPPool) {
+		if breakers {
+			p.SetPeerBreaker(&geecache.BreakerOptions{})
+		}
+		p.SetPeerRateLimit("*", peerRate, peerBurst)
+		if retries > 1 {
+			p.SetRetryPolicy(&geecache.RetryPolicy{MaxAttempts: retries})
+		}
+		if hedge {
+			p.SetHedging(&geecache.HedgePolicy{})
+		}
+	})
+	if preloadFile != "" {
+		go preload(gee, preloadFile, preloadConcurrency) //预热期间照常提供服务
+	}
+	startCacheServer(server)
+	if snapshot != "" {
+		saveSnapshot(gee, snapshot)
 	}
-	startCacheServer(addrMap[port], []string(addrs), gee)
+	gee.Close() //停止后台任务，释放缓存
 }